@@ -0,0 +1,148 @@
+package goftp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// DialActive makes the session use active mode: the server connects to a
+// port this host listens on, announced with PORT or EPRT. Passive mode is
+// the default.
+func DialActive() DialOption {
+	return func(ftp *FTP) {
+		ftp.active = true
+	}
+}
+
+// prepareData negotiates the data connection of the next command, and
+// returns the function opening it once the command is sent
+func (ftp *FTP) prepareData() (func() (net.Conn, error), error) {
+	if ftp.active && ftp.replay == nil {
+		return ftp.listenData()
+	}
+
+	port, err := ftp.Pasv()
+	if err != nil {
+		return nil, err
+	}
+	return func() (net.Conn, error) {
+		return ftp.newConnection(port)
+	}, nil
+}
+
+// listenData listens for the server on the address of the control
+// connection, and announces it with PORT, or EPRT for IPv6
+func (ftp *FTP) listenData() (func() (net.Conn, error), error) {
+	host, _, err := net.SplitHostPort(ftp.conn.LocalAddr().String())
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+
+	addr := l.Addr().(*net.TCPAddr)
+	if ip := addr.IP.To4(); ip != nil {
+		_, err = ftp.cmd(StatusOK, "PORT %d,%d,%d,%d,%d,%d", ip[0], ip[1], ip[2], ip[3], addr.Port>>8, addr.Port&0xff)
+	} else {
+		_, err = ftp.cmd(StatusOK, "EPRT |2|%s|%d|", addr.IP, addr.Port)
+	}
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return func() (net.Conn, error) {
+		return ftp.wrapData(&activeConn{listener: l, ftp: ftp, start: time.Now()}), nil
+	}, nil
+}
+
+// activeTimeout bounds the wait for the server to connect in active mode
+const activeTimeout = 10 * time.Second
+
+// activeConn is a data connection in active mode. The server connection is
+// accepted on first use, as a command failing with no connection at all is
+// only known from its reply, which is read after opening.
+type activeConn struct {
+	listener net.Listener
+	ftp      *FTP
+	start    time.Time
+
+	once sync.Once
+	conn net.Conn
+	err  error
+}
+
+func (c *activeConn) accept() error {
+	c.once.Do(func() {
+		if l, ok := c.listener.(*net.TCPListener); ok {
+			l.SetDeadline(time.Now().Add(activeTimeout))
+		}
+		c.conn, c.err = c.listener.Accept()
+		c.listener.Close()
+		if o := c.ftp.observer; o != nil {
+			o.DataConnected(c.listener.Addr().String(), time.Since(c.start), c.err)
+		}
+	})
+	return c.err
+}
+
+func (c *activeConn) Read(p []byte) (int, error) {
+	if err := c.accept(); err != nil {
+		return 0, err
+	}
+	return c.conn.Read(p)
+}
+
+func (c *activeConn) Write(p []byte) (int, error) {
+	if err := c.accept(); err != nil {
+		return 0, err
+	}
+	return c.conn.Write(p)
+}
+
+func (c *activeConn) Close() error {
+	c.once.Do(func() {
+		c.err = net.ErrClosed
+	})
+	c.listener.Close()
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *activeConn) LocalAddr() net.Addr {
+	return c.listener.Addr()
+}
+
+// RemoteAddr is the address listened on until the server connects
+func (c *activeConn) RemoteAddr() net.Addr {
+	if c.conn != nil {
+		return c.conn.RemoteAddr()
+	}
+	return c.listener.Addr()
+}
+
+func (c *activeConn) SetDeadline(t time.Time) error {
+	if err := c.accept(); err != nil {
+		return err
+	}
+	return c.conn.SetDeadline(t)
+}
+
+func (c *activeConn) SetReadDeadline(t time.Time) error {
+	if err := c.accept(); err != nil {
+		return err
+	}
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *activeConn) SetWriteDeadline(t time.Time) error {
+	if err := c.accept(); err != nil {
+		return err
+	}
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,249 @@
+package goftp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// AnonymousPassword is the email address sent as password by
+// LoginAnonymous when none is given
+var AnonymousPassword = "anonymous@"
+
+// Authenticator logs a session in. Servers with an unusual login sequence
+// can be handled by an Authenticator sending its own commands, with RawCmd.
+type Authenticator interface {
+	Authenticate(ftp *FTP) error
+}
+
+// AuthenticatorFunc is an Authenticator calling the function itself
+type AuthenticatorFunc func(ftp *FTP) error
+
+// Authenticate calls f(ftp)
+func (f AuthenticatorFunc) Authenticate(ftp *FTP) error {
+	return f(ftp)
+}
+
+// Password is the USER and PASS login, see Login
+func Password(username, password string) Authenticator {
+	return &passwordAuth{username: username, password: password}
+}
+
+// Account is the USER, PASS and ACCT login, see LoginAccount
+func Account(username, password, account string) Authenticator {
+	return &passwordAuth{username: username, password: password, account: account}
+}
+
+// Certificate logs username in on the certificate sent during AuthTLS.
+// The server must accept USER alone, typically with a 232 reply.
+func Certificate(username string) Authenticator {
+	return &passwordAuth{username: username, certificate: true}
+}
+
+// Anonymous is the anonymous login, see LoginAnonymous
+func Anonymous(email string) Authenticator {
+	if email == "" {
+		email = AnonymousPassword
+	}
+	return &passwordAuth{username: "anonymous", password: email}
+}
+
+type passwordAuth struct {
+	username, password, account string
+	certificate                 bool
+}
+
+// Authenticate follows the replies of RFC 959: USER may be enough (230, or
+// 232 after a security exchange), or need PASS (331) or ACCT (332), and so
+// may PASS.
+func (a *passwordAuth) Authenticate(ftp *FTP) error {
+	line, err := ftp.exchange("USER %s", a.username)
+	if err == nil && strings.HasPrefix(line, StatusUserOK) && !a.certificate {
+		line, err = ftp.exchange("PASS %s", a.password)
+	}
+	if err == nil && strings.HasPrefix(line, StatusAccountNeeded) && a.account != "" {
+		line, err = ftp.exchange("ACCT %s", a.account)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch line[:min(3, len(line))] {
+	case StatusLoggedIn, StatusLoggedInSecurity, StatusSuperfluous:
+	default:
+		return errors.New(line)
+	}
+
+	ftp.identity.User = a.username
+	ftp.identity.Reply = strings.TrimRight(line, "\r\n")
+	ftp.identity.Authorized = strings.HasPrefix(line, StatusLoggedInSecurity)
+	return nil
+}
+
+// exchange sends a command and returns its reply, whatever its code
+func (ftp *FTP) exchange(command string, args ...interface{}) (string, error) {
+	if err := ftp.send(command, args...); err != nil {
+		return "", err
+	}
+	return ftp.receive()
+}
+
+// LoginWith logs in with auth
+func (ftp *FTP) LoginWith(auth Authenticator) error {
+	if err := auth.Authenticate(ftp); err != nil {
+		return err
+	}
+
+	ftp.features = nil
+	return nil
+}
+
+// Login to the server with provided username and password.
+// Typical default may be ("anonymous","").
+func (ftp *FTP) Login(username string, password string) (err error) {
+	return ftp.LoginWith(Password(username, password))
+}
+
+// LoginAccount logs in with username and password, and gives account when
+// the server asks for one with a 332 reply
+func (ftp *FTP) LoginAccount(username, password, account string) error {
+	return ftp.LoginWith(Account(username, password, account))
+}
+
+// LoginAnonymous logs in as anonymous, with email as password, or
+// AnonymousPassword when empty
+func (ftp *FTP) LoginAnonymous(email string) error {
+	return ftp.LoginWith(Anonymous(email))
+}
+
+// Identity is what a session knows of its authentication
+type Identity struct {
+	// User is the name logged in with, and Reply the last reply to the
+	// login, which often names the account the server mapped it to
+	User  string
+	Reply string
+
+	// Authorized is set when the server logged the user in on the
+	// security exchange, with a 232 reply
+	Authorized bool
+
+	// Mechanism is the security mechanism negotiated with AUTH, like "TLS"
+	Mechanism string
+
+	// Certificate is the client certificate sent in the TLS handshake, and
+	// PeerCertificates the chain of the server
+	Certificate      *x509.Certificate
+	PeerCertificates []*x509.Certificate
+}
+
+// Identity returns the authentication of the session
+func (ftp *FTP) Identity() Identity {
+	id := ftp.identity
+	id.Certificate = ftp.clientCertificate
+	if conn, ok := ftp.conn.(*tls.Conn); ok {
+		id.PeerCertificates = conn.ConnectionState().PeerCertificates
+	}
+	return id
+}
+
+// keepClientCertificate returns a copy of config that keeps the client
+// certificate chosen in handshakes, for Identity
+func (ftp *FTP) keepClientCertificate(config *tls.Config) *tls.Config {
+	if config == nil {
+		return nil
+	}
+
+	config = config.Clone()
+	get, certificates := config.GetClientCertificate, config.Certificates
+	config.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		cert := &tls.Certificate{}
+		if get != nil {
+			var err error
+			if cert, err = get(info); err != nil {
+				return nil, err
+			}
+		} else {
+			// The choice crypto/tls makes without GetClientCertificate
+			for i := range certificates {
+				if info.SupportsCertificate(&certificates[i]) == nil {
+					cert = &certificates[i]
+					break
+				}
+			}
+		}
+
+		if len(cert.Certificate) > 0 {
+			leaf := cert.Leaf
+			if leaf == nil {
+				leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+			}
+			ftp.clientCertificate = leaf
+		}
+		return cert, nil
+	}
+	return config
+}
+
+// Mechanism is a security mechanism of RFC 2228 other than TLS, whose
+// security data is exchanged with ADAT
+type Mechanism interface {
+	// Name is the argument of AUTH, like "GSSAPI"
+	Name() string
+
+	// Step returns the data to send with ADAT, given the data of the last
+	// 334 or 335 reply, empty at first. It is called once more with the
+	// data of the final 235 reply, if any, and its result ignored.
+	Step(challenge []byte) ([]byte, error)
+}
+
+// Auth negotiates m with AUTH and ADAT. Commands are not protected by the
+// mechanism afterwards.
+func (ftp *FTP) Auth(m Mechanism) error {
+	line, err := ftp.exchange("AUTH %s", m.Name())
+	for err == nil && (strings.HasPrefix(line, StatusSecurityDataNeeded) || strings.HasPrefix(line, StatusSecurityDataMore)) {
+		var challenge, response []byte
+		if challenge, err = securityData(line); err != nil {
+			return err
+		}
+		if response, err = m.Step(challenge); err != nil {
+			return err
+		}
+		line, err = ftp.exchange("ADAT %s", base64.StdEncoding.EncodeToString(response))
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasPrefix(line, StatusSecurityDataOK):
+		challenge, err := securityData(line)
+		if err != nil {
+			return err
+		}
+		if len(challenge) > 0 {
+			if _, err = m.Step(challenge); err != nil {
+				return err
+			}
+		}
+	case strings.HasPrefix(line, StatusAuthOK):
+		// Accepted without security data
+	default:
+		return errors.New(line)
+	}
+
+	ftp.identity.Mechanism = m.Name()
+	ftp.features = nil
+	return nil
+}
+
+// securityData decodes the "ADAT=" data of a reply, if any
+func securityData(line string) ([]byte, error) {
+	i := strings.Index(line, "ADAT=")
+	if i < 0 {
+		return nil, nil
+	}
+	data, _, _ := strings.Cut(strings.TrimRight(line[i+len("ADAT="):], "\r\n"), " ")
+	return base64.StdEncoding.DecodeString(data)
+}
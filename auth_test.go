@@ -0,0 +1,217 @@
+package goftp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestLoginAccount(t *testing.T) {
+	s := ftptest.NewUnstartedServer()
+	s.Users = map[string]string{"alice": "secret"}
+	s.Accounts = map[string]string{"alice": "billing"}
+	s.Start()
+	defer s.Close()
+
+	connection, err := Connect(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.Login("alice", "secret"); err == nil || !strings.HasPrefix(err.Error(), StatusAccountNeeded) {
+		t.Errorf("Login without account = %v, want 332 reply", err)
+	}
+	if err = connection.LoginAccount("alice", "secret", "billing"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = connection.Pwd(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoginAnonymous(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection, err := Connect(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.LoginAnonymous(""); err != nil {
+		t.Fatal(err)
+	}
+	if err = connection.LoginAnonymous("me@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"USER anonymous", "PASS " + AnonymousPassword, "USER anonymous", "PASS me@example.com"}
+	if got := s.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %q, want %q", got, want)
+	}
+}
+
+func TestLoginUserOnly(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.Handle("USER", func(c *ftptest.Conn, arg string) {
+		c.Reply(230, "No password needed.")
+	})
+
+	connection, err := Connect(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.Login("guest", "ignored"); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Commands(); len(got) != 1 {
+		t.Errorf("commands = %q, want USER only", got)
+	}
+}
+
+func TestLoginWith(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection, err := Connect(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	// A server wanting a NOOP before the login
+	err = connection.LoginWith(AuthenticatorFunc(func(ftp *FTP) error {
+		if code, line := ftp.RawCmd("NOOP"); code != 200 {
+			return errors.New(line)
+		}
+		return Password("user", "pass").Authenticate(ftp)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Commands(); len(got) != 3 || got[0] != "NOOP" {
+		t.Errorf("commands = %q", got)
+	}
+}
+
+func clientCertificate(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertificateLogin(t *testing.T) {
+	s := ftptest.NewUnstartedServer()
+	s.CertificateLogin = true
+	s.Users = map[string]string{}
+	s.Start()
+	defer s.Close()
+
+	connection, err := Connect(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	config := s.ClientTLSConfig()
+	config.Certificates = []tls.Certificate{clientCertificate(t, "partner")}
+	if err = connection.AuthTLS(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = connection.LoginWith(Certificate("other")); err == nil {
+		t.Error("certificate login as another user succeeded")
+	}
+	if err = connection.LoginWith(Certificate("partner")); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Commands(); got[len(got)-1] != "USER partner" {
+		t.Errorf("commands = %q, want no PASS", got)
+	}
+
+	id := connection.Identity()
+	if id.User != "partner" || !id.Authorized || id.Mechanism != "TLS" || !strings.HasPrefix(id.Reply, "232 ") {
+		t.Errorf("Identity = %+v", id)
+	}
+	if id.Certificate == nil || id.Certificate.Subject.CommonName != "partner" {
+		t.Errorf("client certificate = %v", id.Certificate)
+	}
+	if len(id.PeerCertificates) == 0 || !id.PeerCertificates[0].Equal(s.Certificate()) {
+		t.Errorf("server certificates = %v", id.PeerCertificates)
+	}
+}
+
+type testMechanism struct {
+	challenges []string
+}
+
+func (m *testMechanism) Name() string { return "TEST" }
+
+func (m *testMechanism) Step(challenge []byte) ([]byte, error) {
+	m.challenges = append(m.challenges, string(challenge))
+	return []byte("response " + string(challenge)), nil
+}
+
+func TestAuthMechanism(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.Handle("AUTH", func(c *ftptest.Conn, arg string) {
+		c.Reply(334, "Send security data.")
+	})
+	s.Handle("ADAT", func(c *ftptest.Conn, arg string) {
+		switch b, _ := base64.StdEncoding.DecodeString(arg); string(b) {
+		case "response ":
+			c.Reply(335, "ADAT=%s", base64.StdEncoding.EncodeToString([]byte("nonce")))
+		case "response nonce":
+			c.Reply(235, "ADAT=%s", base64.StdEncoding.EncodeToString([]byte("done")))
+		default:
+			c.Reply(535, "Failed security check.")
+		}
+	})
+
+	connection, err := Connect(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	m := &testMechanism{}
+	if err = connection.Auth(m); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", "nonce", "done"}; !reflect.DeepEqual(m.challenges, want) {
+		t.Errorf("challenges = %q, want %q", m.challenges, want)
+	}
+	if got := connection.Identity().Mechanism; got != "TEST" {
+		t.Errorf("Mechanism = %q", got)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// lineEditor reads lines typed on a terminal in raw mode, with history
+// recalled by the up and down arrows and completion on tab
+type lineEditor struct {
+	in      *bufio.Reader
+	out     io.Writer
+	prompt  string
+	history []string
+
+	// complete returns line completed, and the candidates to show when
+	// it could not be
+	complete func(line string) (string, []string)
+}
+
+// readLine returns the next line, or io.EOF on Ctrl-D on an empty line
+func (e *lineEditor) readLine() (string, error) {
+	fmt.Fprint(e.out, e.prompt)
+	var line []rune
+	h := len(e.history)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			s := string(line)
+			if strings.TrimSpace(s) != "" {
+				e.history = append(e.history, s)
+			}
+			return s, nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n"+e.prompt)
+			line = nil
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case 8, 127: // Backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(e.out, "\b \b")
+			}
+		case 21: // Ctrl-U
+			line = nil
+			e.redraw(line)
+		case '\t':
+			if e.complete == nil {
+				continue
+			}
+			completed, candidates := e.complete(string(line))
+			if len(candidates) > 0 {
+				fmt.Fprint(e.out, "\r\n"+strings.Join(candidates, "  ")+"\r\n")
+			}
+			line = []rune(completed)
+			e.redraw(line)
+		case 27: // Escape sequences, of which the arrows up and down
+			if b, _ := e.in.ReadByte(); b != '[' {
+				continue
+			}
+			switch b, _ := e.in.ReadByte(); {
+			case b == 'A' && h > 0:
+				h--
+				line = []rune(e.history[h])
+			case b == 'B' && h < len(e.history):
+				h++
+				line = nil
+				if h < len(e.history) {
+					line = []rune(e.history[h])
+				}
+			default:
+				continue
+			}
+			e.redraw(line)
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line, r)
+				fmt.Fprint(e.out, string(r))
+			}
+		}
+	}
+}
+
+// redraw clears the terminal line and prints the prompt and line
+func (e *lineEditor) redraw(line []rune) {
+	fmt.Fprint(e.out, "\r\x1b[K"+e.prompt+string(line))
+}
+
+// completeLine completes the last word of line, split as splitLine does,
+// with the names listFor returns for the words before it
+func completeLine(line string, listFor func(words []string) func(dir string) []string) (string, []string) {
+	words, start, _ := scanLine(line)
+	word := ""
+	if start < len(line) {
+		word, words = words[len(words)-1], words[:len(words)-1]
+	}
+
+	completed, candidates := completeWord(word, listFor(words))
+	if completed == "" {
+		return line, candidates
+	}
+	return line[:start] + completed, candidates
+}
+
+// completeWord returns the completion of word with the names list returns
+// for its directory, directories ending with "/", escaped for splitLine. It
+// returns "" when word could not be extended, and the candidates when there
+// are several.
+func completeWord(word string, list func(dir string) []string) (string, []string) {
+	dir, base := splitWord(word)
+
+	var matches []string
+	for _, name := range list(dir) {
+		if strings.HasPrefix(name, base) {
+			matches = append(matches, name)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", nil
+	case 1:
+		if strings.HasSuffix(matches[0], "/") {
+			return escapeWord(dir + matches[0]), nil
+		}
+		return escapeWord(dir+matches[0]) + " ", nil
+	}
+
+	// The common prefix is cut between runes
+	common := []rune(matches[0])
+	for _, m := range matches[1:] {
+		n := 0
+		for _, r := range m {
+			if n == len(common) || common[n] != r {
+				break
+			}
+			n++
+		}
+		common = common[:n]
+	}
+	if prefix := string(common); len(prefix) > len(base) {
+		return escapeWord(dir + prefix), nil
+	}
+	return "", matches
+}
+
+// escapeWord escapes the spaces, quotes and backslashes of word with \
+func escapeWord(word string) string {
+	var b strings.Builder
+	for _, r := range word {
+		if unicode.IsSpace(r) || strings.ContainsRune(`'"\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// splitWord splits word after its last slash
+func splitWord(word string) (dir, base string) {
+	i := strings.LastIndex(word, "/") + 1
+	return word[:i], word[i:]
+}
+
+// splitLine splits a command line in words, separated by spaces unless
+// quoted with ' or ", or escaped with \
+func splitLine(line string) ([]string, error) {
+	words, _, quote := scanLine(line)
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	return words, nil
+}
+
+// scanLine splits line as splitLine does, returning the offset where the
+// last word starts, len(line) when line ends with a space, and the quote
+// left open at its end
+func scanLine(line string) (words []string, start int, quote rune) {
+	var word strings.Builder
+	inWord, escaped := false, false
+	start = len(line)
+
+	for i, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '\'' || r == '"'):
+			quote = r
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote == 0 && unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord, start = false, len(line)
+			}
+			continue
+		default:
+			word.WriteRune(r)
+		}
+		if !inWord {
+			inWord, start = true, i
+		}
+	}
+
+	if escaped {
+		// A backslash ending the line is kept
+		word.WriteByte('\\')
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, start, quote
+}
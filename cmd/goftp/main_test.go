@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+// runCmd runs the command line args against s, returning the exit status
+// and output
+func runCmd(t *testing.T, s *ftptest.Server, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-url", "ftp://" + s.Addr + "/"}, args...)
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCommands(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/dir/a.txt", []byte("hello"))
+	s.WriteFile("/dir/sub/b.txt", []byte("bb"))
+
+	if code, out, _ := runCmd(t, s, "", "ls", "/dir"); code != 0 || !strings.Contains(out, " a.txt\n") || !strings.Contains(out, " sub\n") {
+		t.Errorf("ls = %d, %q", code, out)
+	}
+
+	code, out, _ := runCmd(t, s, "", "ls", "-r", "-json", "/dir")
+	var entries []jsonEntry
+	if err := json.Unmarshal([]byte(out), &entries); code != 0 || err != nil {
+		t.Fatalf("ls -r -json = %d, %q, %v", code, out, err)
+	}
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path+":"+e.Type)
+	}
+	if got := strings.Join(paths, " "); got != "/dir/a.txt:file /dir/sub:dir /dir/sub/b.txt:file" {
+		t.Errorf("ls -r -json paths = %s", got)
+	}
+
+	if code, out, _ := runCmd(t, s, "", "get", "/dir/a.txt", "-"); code != 0 || out != "hello" {
+		t.Errorf("get to stdout = %d, %q", code, out)
+	}
+	local := filepath.Join(t.TempDir(), "dir")
+	if code, _, stderr := runCmd(t, s, "", "get", "/dir", local); code != 0 {
+		t.Fatalf("get dir = %d, %s", code, stderr)
+	}
+	if got, err := os.ReadFile(filepath.Join(local, "sub", "b.txt")); err != nil || string(got) != "bb" {
+		t.Errorf("downloaded %q, %v", got, err)
+	}
+
+	if code, _, stderr := runCmd(t, s, "piped", "put", "-", "/dir/c.txt"); code != 0 {
+		t.Errorf("put stdin = %d, %s", code, stderr)
+	}
+	if code, _, stderr := runCmd(t, s, "", "put", local, "/copy"); code != 0 {
+		t.Errorf("put dir = %d, %s", code, stderr)
+	}
+	if got, err := s.ReadFile("/copy/sub/b.txt"); err != nil || string(got) != "bb" {
+		t.Errorf("uploaded %q, %v", got, err)
+	}
+
+	for _, args := range [][]string{
+		{"mkdir", "-p", "/x/y"},
+		{"mv", "/dir/c.txt", "/x/y/c.txt"},
+		{"rm", "-r", "/copy"},
+	} {
+		if code, _, stderr := runCmd(t, s, "", args...); code != 0 {
+			t.Errorf("%s = %d, %s", args, code, stderr)
+		}
+	}
+	if got, _ := s.ReadFile("/x/y/c.txt"); string(got) != "piped" {
+		t.Errorf("moved file = %q", got)
+	}
+	if exists, _ := s.Stat("/copy"); exists {
+		t.Error("rm -r left /copy")
+	}
+
+	if code, out, _ := runCmd(t, s, "", "size", "/dir/a.txt"); code != 0 || out != "5\n" {
+		t.Errorf("size = %d, %q", code, out)
+	}
+	if code, out, _ := runCmd(t, s, "", "stat", "-json", "/dir/a.txt"); code != 0 || !strings.Contains(out, `"size":5`) {
+		t.Errorf("stat = %d, %q", code, out)
+	}
+	if code, out, _ := runCmd(t, s, "", "raw", "NOOP"); code != 0 || !strings.HasPrefix(out, "200 ") {
+		t.Errorf("raw NOOP = %d, %q", code, out)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	if code, _, stderr := runCmd(t, s, "", "rm", "/missing"); code != 1 || !strings.Contains(stderr, "goftp rm: 550") {
+		t.Errorf("rm missing = %d, %q", code, stderr)
+	}
+	if code, _, _ := runCmd(t, s, "", "raw", "BOGUS"); code != 1 {
+		t.Errorf("raw failing command = %d", code)
+	}
+	if code, _, _ := runCmd(t, s, "", "mv", "/only"); code != 2 {
+		t.Errorf("mv with one argument = %d", code)
+	}
+	if code, _, _ := runCmd(t, s, "", "frobnicate"); code != 2 {
+		t.Errorf("unknown command = %d", code)
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"-url", "", "size", "x"}, nil, &stderr, &stderr); code != 1 || !strings.Contains(stderr.String(), "no server") {
+		t.Errorf("no server = %d, %q", code, stderr.String())
+	}
+}
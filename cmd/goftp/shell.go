@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dutchcoders/goftp"
+)
+
+func init() {
+	// Registered here as the shell runs the other commands
+	commands["shell"] = shell
+}
+
+// shellHelp is printed by the help command of the shell
+const shellHelp = `commands:
+  cd dir                  change the remote directory
+  pwd                     print the remote directory
+  lcd [dir]               change the local directory, home by default
+  lpwd                    print the local directory
+  mget pattern...         download the remote files matching the patterns,
+                          ** matching any number of directories, into the
+                          local directory
+  mput pattern...         upload the local files matching the patterns
+  help                    print this help
+  quit, exit, bye         end the session
+and ls, get, put, mkdir, rm, mv, stat, size and raw as on the command line.
+Tab completes commands and paths, up and down recall the history.
+`
+
+// readOnly are the commands run again after reconnecting, which may have
+// been lost before the server ran them and do no harm when it did
+var readOnly = map[string]bool{
+	"ls": true, "get": true, "stat": true, "size": true, "cd": true, "pwd": true, "mget": true,
+}
+
+// modifying are the commands after which listings are stale
+var modifying = map[string]bool{
+	"put": true, "mput": true, "mkdir": true, "rm": true, "mv": true, "raw": true,
+}
+
+// shellState is the state of an interactive session
+type shellState struct {
+	*cli
+	cwd string
+
+	// listings caches the names in remote directories for completion
+	listings map[string][]string
+}
+
+// shell reads commands from stdin and runs them on one session until quit
+// or the end of the input. A lost connection is dialed again, back to the
+// remote directory, and read-only commands are retried.
+func shell(c *cli, args []string) error {
+	if _, err := c.parse(args, 0, 0, ""); err != nil {
+		return err
+	}
+	sh := &shellState{cli: c, listings: map[string][]string{}}
+	sh.cwd, _ = c.ftp.Pwd()
+
+	read := sh.lineReader()
+	for {
+		line, err := read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		args, err := splitLine(line)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "goftp: %v\n", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "quit", "exit", "bye":
+			return nil
+		}
+
+		if err := sh.exec(args); err != nil && err != errUsage {
+			fmt.Fprintf(c.stderr, "goftp %s: %v\n", args[0], err)
+		}
+	}
+}
+
+// lineReader returns the function reading the command lines: with the line
+// editor when stdin is a terminal, else line by line without prompt
+func (sh *shellState) lineReader() func() (string, error) {
+	if f, ok := sh.stdin.(*os.File); ok {
+		if restore, err := makeRaw(f.Fd()); err == nil {
+			restore()
+			editor := &lineEditor{
+				in:       bufio.NewReader(f),
+				out:      sh.stdout,
+				prompt:   "ftp> ",
+				complete: sh.complete,
+			}
+			return func() (string, error) {
+				// Commands run with the terminal restored
+				restore, err := makeRaw(f.Fd())
+				if err != nil {
+					return "", err
+				}
+				defer restore()
+				return editor.readLine()
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(sh.stdin)
+	return func() (string, error) {
+		if scanner.Scan() {
+			return scanner.Text(), nil
+		}
+		if err := scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+}
+
+// exec runs a command, reconnecting when the connection was lost. Only
+// read-only commands are run again, others may have taken effect before the
+// reply was lost.
+func (sh *shellState) exec(args []string) error {
+	err := sh.dispatch(args)
+	if err == nil || !connectionLost(err) {
+		return err
+	}
+
+	fmt.Fprintf(sh.stderr, "goftp: %s, reconnecting\n", strings.TrimSpace(err.Error()))
+	if rerr := sh.reconnect(); rerr != nil {
+		return rerr
+	}
+	if !readOnly[args[0]] {
+		return err
+	}
+	return sh.dispatch(args)
+}
+
+// dispatch runs a command of the shell or of the command line
+func (sh *shellState) dispatch(args []string) error {
+	name, args := args[0], args[1:]
+	sh.flags = flag.NewFlagSet(name, flag.ContinueOnError)
+	sh.flags.SetOutput(sh.stderr)
+	if modifying[name] {
+		defer clear(sh.listings)
+	}
+
+	switch name {
+	case "cd":
+		return sh.cd(args)
+	case "pwd":
+		return sh.pwd(args)
+	case "lcd":
+		return sh.lcd(args)
+	case "lpwd":
+		return sh.lpwd(args)
+	case "mget":
+		return sh.mget(args)
+	case "mput":
+		return sh.mput(args)
+	case "help":
+		fmt.Fprint(sh.stdout, shellHelp)
+		return nil
+	case "shell":
+		return errors.New("already in the shell")
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		return errors.New("unknown command, try help")
+	}
+	return cmd(sh.cli, args)
+}
+
+// reconnect replaces the session with a new one in the same directory
+func (sh *shellState) reconnect() error {
+	if sh.ftp != nil {
+		sh.ftp.Close()
+		sh.ftp = nil
+	}
+	ftp, err := sh.session()
+	if err != nil {
+		return err
+	}
+	if sh.cwd != "" {
+		return ftp.Cwd(sh.cwd)
+	}
+	return nil
+}
+
+// connectionLost reports whether err means the session is gone
+func connectionLost(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.As(err, &netErr) ||
+		strings.HasPrefix(err.Error(), goftp.StatusNotAvailable+" ")
+}
+
+func (sh *shellState) cd(args []string) error {
+	args, err := sh.parse(args, 1, 1, "dir")
+	if err != nil {
+		return err
+	}
+	if err = sh.ftp.Cwd(args[0]); err != nil {
+		return err
+	}
+	sh.cwd, err = sh.ftp.Pwd()
+	return err
+}
+
+func (sh *shellState) pwd(args []string) error {
+	if _, err := sh.parse(args, 0, 0, ""); err != nil {
+		return err
+	}
+	dir, err := sh.ftp.Pwd()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.stdout, dir)
+	return nil
+}
+
+func (sh *shellState) lcd(args []string) error {
+	if err := sh.flags.Parse(args); err != nil || sh.flags.NArg() > 1 {
+		fmt.Fprintln(sh.stderr, "usage: lcd [dir]")
+		return errUsage
+	}
+	dir := sh.flags.Arg(0)
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dir = home
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	return sh.lpwd(nil)
+}
+
+func (sh *shellState) lpwd(args []string) error {
+	if err := sh.flags.Parse(args); err != nil || sh.flags.NArg() > 0 {
+		fmt.Fprintln(sh.stderr, "usage: lpwd")
+		return errUsage
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(sh.stdout, dir)
+	return nil
+}
+
+func (sh *shellState) mget(args []string) error {
+	args, err := sh.parse(args, 1, -1, "pattern...")
+	if err != nil {
+		return err
+	}
+	for _, pattern := range args {
+		names, err := sh.expand(pattern)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no match for %s", pattern)
+		}
+		for _, name := range names {
+			fmt.Fprintln(sh.stdout, name)
+			if err := sh.ftp.Download(name, path.Base(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (sh *shellState) mput(args []string) error {
+	args, err := sh.parse(args, 1, -1, "pattern...")
+	if err != nil {
+		return err
+	}
+	for _, pattern := range args {
+		names, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no match for %s", pattern)
+		}
+		for _, name := range names {
+			fmt.Fprintln(sh.stdout, name)
+			if err := sh.ftp.Upload(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expand returns the remote files matching pattern, or pattern itself when
+// it has no metacharacter
+func (sh *shellState) expand(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return []string{pattern}, nil
+	}
+	return sh.ftp.Glob(pattern, goftp.GlobFiles())
+}
+
+// complete completes the last word of line: a command name, or a local or
+// remote path depending on the command and argument
+func (sh *shellState) complete(line string) (string, []string) {
+	return completeLine(line, func(words []string) func(dir string) []string {
+		switch {
+		case len(words) == 0:
+			return func(string) []string { return commandNames() }
+		case localArgument(words[0], len(words)):
+			return localNames
+		}
+		return sh.remoteNames
+	})
+}
+
+// localArgument reports whether the argument at i of command is local
+func localArgument(command string, i int) bool {
+	switch command {
+	case "lcd", "mput":
+		return true
+	case "put":
+		return i == 1
+	case "get":
+		return i == 2
+	}
+	return false
+}
+
+// commandNames returns the names of the commands of the shell
+func commandNames() []string {
+	names := []string{"cd", "pwd", "lcd", "lpwd", "mget", "mput", "help", "quit", "exit", "bye"}
+	for name := range commands {
+		if name != "shell" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// localNames returns the names in the local directory dir, directories
+// ending with "/"
+func localNames(dir string) []string {
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// remoteNames returns the names in the remote directory dir, directories
+// ending with "/", from the listings cached since the last change
+func (sh *shellState) remoteNames(dir string) []string {
+	abs := dir
+	if !path.IsAbs(abs) {
+		abs = path.Join(sh.cwd, dir)
+	}
+	if names, ok := sh.listings[abs]; ok {
+		return names
+	}
+	if sh.ftp == nil {
+		return nil
+	}
+
+	entries, err := sh.ftp.ListEntries(abs)
+	if err != nil {
+		return nil
+	}
+	names := []string{}
+	for _, e := range entries {
+		switch {
+		case e.Name == "." || e.Name == "..":
+			continue
+		case e.Type == goftp.EntryTypeDir:
+			names = append(names, e.Name+"/")
+		default:
+			names = append(names, e.Name)
+		}
+	}
+	sort.Strings(names)
+	sh.listings[abs] = names
+	return names
+}
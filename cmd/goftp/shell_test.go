@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestShell(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/dir/a.txt", []byte("aa"))
+	s.WriteFile("/dir/b.txt", []byte("bb"))
+	s.WriteFile("/dir/c.csv", []byte("cc"))
+	s.WriteFile("/dir/sub/deep/d.txt", []byte("dd"))
+
+	local := t.TempDir()
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(local)
+	os.Mkdir("down", 0755)
+	os.WriteFile("up 1.log", []byte("one"), 0644)
+	os.WriteFile("up2.log", []byte("two"), 0644)
+
+	s.InjectFault("SIZE", ftptest.Fault{Code: 421, Message: "Idle timeout", Times: 1})
+
+	script := `cd dir
+pwd
+mput up*.log
+lcd down
+mget *.txt "up 1.log"
+mget sub/**
+size a.txt
+frobnicate
+cd
+quit
+ls
+`
+	code, out, stderr := runCmd(t, s, script)
+	if code != 0 {
+		t.Fatalf("shell = %d, %s", code, stderr)
+	}
+	if !strings.HasPrefix(out, "/dir\n") || !strings.HasSuffix(out, "\n2\n") {
+		t.Errorf("output = %q", out)
+	}
+	for _, want := range []string{"421 Idle timeout, reconnecting", "goftp frobnicate: unknown command", "usage: cd dir"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("stderr %q lacks %q", stderr, want)
+		}
+	}
+
+	if got, _ := s.ReadFile("/dir/up 1.log"); string(got) != "one" {
+		t.Errorf("uploaded %q", got)
+	}
+	for name, want := range map[string]string{"a.txt": "aa", "b.txt": "bb", "up 1.log": "one", "d.txt": "dd"} {
+		if got, err := os.ReadFile(filepath.Join(local, "down", name)); string(got) != want {
+			t.Errorf("downloaded %s = %q, %v", name, got, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(local, "down", "c.csv")); err == nil {
+		t.Error("mget *.txt downloaded c.csv")
+	}
+	for _, name := range []string{"sub", "deep"} {
+		if _, err := os.Stat(filepath.Join(local, "down", name)); err == nil {
+			t.Errorf("mget sub/** downloaded the directory %s", name)
+		}
+	}
+
+	// The reconnected session is back in /dir, and ls after quit never ran
+	commands := strings.Join(s.Commands(), "\n")
+	if !strings.Contains(commands, "SIZE a.txt\n") || strings.Count(commands, "CWD /dir") != 1 || strings.Contains(commands, "LIST") {
+		t.Errorf("commands:\n%s", commands)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	tree := map[string][]string{
+		"":      {"data/", "doc.txt", "my file.txt", "notes"},
+		"data/": {"2026-01/", "2026-02/"},
+		"uni/":  {"é1", "è2", "éa"},
+	}
+	list := func(dir string) []string { return tree[dir] }
+
+	for _, tt := range []struct {
+		word, want string
+		candidates []string
+	}{
+		{"n", "notes ", nil},
+		{"da", "data/", nil},
+		{"d", "", []string{"data/", "doc.txt"}},
+		{"data/", "data/2026-0", nil},
+		{"data/2026-0", "", []string{"2026-01/", "2026-02/"}},
+		{"x", "", nil},
+		{"uni/", "", []string{"é1", "è2", "éa"}},
+		{"uni/é", "", []string{"é1", "éa"}},
+		{"my", `my\ file.txt `, nil},
+	} {
+		got, candidates := completeWord(tt.word, list)
+		if got != tt.want || !reflect.DeepEqual(candidates, tt.candidates) {
+			t.Errorf("completeWord(%q) = %q, %q, want %q, %q", tt.word, got, candidates, tt.want, tt.candidates)
+		}
+	}
+
+	listFor := func([]string) func(string) []string { return list }
+	for _, line := range []string{"get my", `get "my f`, `get my\ f`, `get 'my'`} {
+		got, _ := completeLine(line, listFor)
+		words, err := splitLine(got)
+		if want := []string{"get", "my file.txt"}; err != nil || !reflect.DeepEqual(words, want) {
+			t.Errorf("completeLine(%q) = %q, split as %q, %v, want %q", line, got, words, err, want)
+		}
+	}
+
+	var out bytes.Buffer
+	editor := &lineEditor{
+		in:     bufio.NewReader(strings.NewReader("get n\t\rxx\x7f\x7fput\x1b[A\r\x04")),
+		out:    &out,
+		prompt: "> ",
+		complete: func(line string) (string, []string) {
+			words := strings.Split(line, " ")
+			completed, candidates := completeWord(words[len(words)-1], list)
+			return strings.Join(append(words[:len(words)-1], completed), " "), candidates
+		},
+	}
+	for _, want := range []string{"get notes ", "get notes "} {
+		if line, err := editor.readLine(); line != want || err != nil {
+			t.Errorf("readLine = %q, %v, want %q", line, err, want)
+		}
+	}
+	if _, err := editor.readLine(); err == nil {
+		t.Error("Ctrl-D did not end the input")
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	for line, want := range map[string][]string{
+		`get  "a b.txt" c\ d`: {"get", "a b.txt", "c d"},
+		`put 'x\y' ""`:        {"put", `x\y`, ""},
+		"   ":                 nil,
+	} {
+		if got, err := splitLine(line); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("splitLine(%q) = %q, %v, want %q", line, got, err, want)
+		}
+	}
+	if _, err := splitLine(`ls "open`); err == nil {
+		t.Error("unterminated quote accepted")
+	}
+}
+
+func TestShellNoRetry(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/a.txt", []byte("aa"))
+	s.InjectFault("RNTO", ftptest.Fault{Code: 421, Message: "Idle timeout", Times: 1})
+
+	code, _, stderr := runCmd(t, s, "mv a.txt b.txt\n")
+	if code != 0 || !strings.Contains(stderr, "reconnecting\ngoftp mv: 421 Idle timeout") {
+		t.Errorf("shell = %d, %q", code, stderr)
+	}
+	commands := strings.Join(s.Commands(), "\n")
+	if n := strings.Count(commands, "RNFR"); n != 1 {
+		t.Errorf("mv sent %d times after reconnecting:\n%s", n, commands)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import "errors"
+
+// makeRaw fails, the shell reading lines without editing
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, errors.New("terminal not supported")
+}
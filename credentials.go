@@ -0,0 +1,272 @@
+package goftp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// ErrNoCredentials is returned by a CredentialProvider without credentials
+// for a host
+var ErrNoCredentials = errors.New("no credentials")
+
+// Credentials are what a session logs in with. Account is sent with ACCT
+// when the server asks for one.
+type Credentials struct {
+	User     string
+	Password string
+	Account  string
+}
+
+// CredentialProvider looks up the credentials for host, or returns
+// ErrNoCredentials. Nil credentials without error count as ErrNoCredentials.
+type CredentialProvider interface {
+	Credentials(host string) (*Credentials, error)
+}
+
+// CredentialFunc is a CredentialProvider calling the function itself, to
+// plug in a secret manager
+type CredentialFunc func(host string) (*Credentials, error)
+
+// Credentials calls f(host)
+func (f CredentialFunc) Credentials(host string) (*Credentials, error) {
+	return f(host)
+}
+
+// Providers returns a CredentialProvider asking each of providers in turn,
+// until one has credentials
+func Providers(providers ...CredentialProvider) CredentialProvider {
+	return CredentialFunc(func(host string) (*Credentials, error) {
+		for _, p := range providers {
+			c, err := p.Credentials(host)
+			if err == nil && c == nil {
+				continue
+			}
+			if !errors.Is(err, ErrNoCredentials) {
+				return c, err
+			}
+		}
+		return nil, ErrNoCredentials
+	})
+}
+
+// Env returns a CredentialProvider reading the environment variables
+// PREFIX_USER, PREFIX_PASSWORD and PREFIX_ACCOUNT, where PREFIX is prefix or
+// "FTP" when empty. Variables for a single host are looked up first, with
+// the host in upper case and its other characters than letters and digits
+// replaced with "_", like FTP_EXAMPLE_COM_USER for ftp.example.com.
+func Env(prefix string) CredentialProvider {
+	if prefix == "" {
+		prefix = "FTP"
+	}
+	return CredentialFunc(func(host string) (*Credentials, error) {
+		name := strings.Map(func(r rune) rune {
+			if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return unicode.ToUpper(r)
+			}
+			return '_'
+		}, host)
+
+		for _, p := range []string{prefix + "_" + name + "_", prefix + "_"} {
+			if user, ok := os.LookupEnv(p + "USER"); ok {
+				return &Credentials{User: user, Password: os.Getenv(p + "PASSWORD"), Account: os.Getenv(p + "ACCOUNT")}, nil
+			}
+		}
+		return nil, ErrNoCredentials
+	})
+}
+
+// Netrc returns a CredentialProvider reading the netrc file at path, or
+// when empty at $NETRC or ~/.netrc. The machine entry of the host is used,
+// or else the default entry. The file is read on each lookup, a missing
+// one having no credentials.
+func Netrc(path string) CredentialProvider {
+	return CredentialFunc(func(host string) (*Credentials, error) {
+		name := path
+		if name == "" {
+			name = os.Getenv("NETRC")
+		}
+		if name == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, ErrNoCredentials
+			}
+			name = filepath.Join(home, ".netrc")
+		}
+
+		data, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNoCredentials
+		} else if err != nil {
+			return nil, err
+		}
+		return parseNetrc(string(data), host)
+	})
+}
+
+// parseNetrc returns the credentials of the machine entry for host in
+// data, or of the default entry
+func parseNetrc(data, host string) (*Credentials, error) {
+	var found, fallback, entry *Credentials
+
+	t := &netrcTokenizer{data: data}
+	for token, ok := t.next(); ok; token, ok = t.next() {
+		value := func() (string, error) {
+			if v, ok := t.next(); ok {
+				return v, nil
+			}
+			return "", fmt.Errorf("netrc: missing value after %q", token)
+		}
+
+		switch token {
+		case "machine":
+			machine, err := value()
+			if err != nil {
+				return nil, err
+			}
+			entry = &Credentials{}
+			if found == nil && strings.EqualFold(machine, host) {
+				found = entry
+			}
+		case "default":
+			entry = &Credentials{}
+			if fallback == nil {
+				fallback = entry
+			}
+		case "login", "password", "account":
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				return nil, fmt.Errorf("netrc: %q outside of an entry", token)
+			}
+			switch token {
+			case "login":
+				entry.User = v
+			case "password":
+				entry.Password = v
+			case "account":
+				entry.Account = v
+			}
+		case "macdef":
+			if _, err := value(); err != nil {
+				return nil, err
+			}
+			t.skipMacro()
+			entry = nil
+		default:
+			return nil, fmt.Errorf("netrc: unknown token %q", token)
+		}
+	}
+
+	if found == nil {
+		found = fallback
+	}
+	if found == nil || found.User == "" {
+		return nil, ErrNoCredentials
+	}
+	return found, nil
+}
+
+// netrcTokenizer splits a netrc file in whitespace separated tokens, which
+// may be double quoted with backslash escapes. Comments starting with "#"
+// are skipped.
+type netrcTokenizer struct {
+	data string
+}
+
+func (t *netrcTokenizer) next() (string, bool) {
+	for {
+		t.data = strings.TrimLeftFunc(t.data, unicode.IsSpace)
+		if !strings.HasPrefix(t.data, "#") {
+			break
+		}
+		_, t.data, _ = strings.Cut(t.data, "\n")
+	}
+
+	switch {
+	case t.data == "":
+		return "", false
+	case t.data[0] == '"':
+		var token strings.Builder
+		i := 1
+		for ; i < len(t.data) && t.data[i] != '"'; i++ {
+			if t.data[i] == '\\' && i+1 < len(t.data) {
+				i++
+			}
+			token.WriteByte(t.data[i])
+		}
+		t.data = t.data[min(i+1, len(t.data)):]
+		return token.String(), true
+	default:
+		end := strings.IndexFunc(t.data, unicode.IsSpace)
+		if end < 0 {
+			end = len(t.data)
+		}
+		token := t.data[:end]
+		t.data = t.data[end:]
+		return token, true
+	}
+}
+
+// skipMacro skips the body of a macdef macro, from the next line up to an
+// empty line
+func (t *netrcTokenizer) skipMacro() {
+	_, body, _ := strings.Cut(t.data, "\n")
+	for body != "" {
+		var line string
+		line, body, _ = strings.Cut(body, "\n")
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+	}
+	t.data = body
+}
+
+// DialCredentials sets the provider of the credentials DialLogin and
+// DialURL log in with, looked up by host
+func DialCredentials(provider CredentialProvider) DialOption {
+	return func(ftp *FTP) {
+		ftp.credentials = provider
+	}
+}
+
+// DialLogin connects to the server at addr (format "host:port") and logs in
+// with the credentials found for its host by the DialCredentials provider,
+// or as anonymous when none are found
+func DialLogin(addr string, opts ...DialOption) (*FTP, error) {
+	object, err := Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = object.loginCredentials(); err != nil {
+		object.Close()
+		return nil, err
+	}
+	return object, nil
+}
+
+// loginCredentials logs in with the credentials of the provider for the
+// host of the session, or as anonymous
+func (ftp *FTP) loginCredentials() error {
+	if ftp.credentials == nil {
+		return ftp.LoginAnonymous("")
+	}
+
+	host, _, err := net.SplitHostPort(ftp.addr)
+	if err != nil {
+		host = ftp.addr
+	}
+	c, err := ftp.credentials.Credentials(host)
+	if errors.Is(err, ErrNoCredentials) || err == nil && c == nil {
+		return ftp.LoginAnonymous("")
+	} else if err != nil {
+		return err
+	}
+	return ftp.LoginAccount(c.User, c.Password, c.Account)
+}
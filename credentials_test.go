@@ -0,0 +1,111 @@
+package goftp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+const testNetrc = `# partners
+machine ftp.example.com login alice password "se cret\"" account billing
+macdef init
+cd /pub
+binary
+
+machine other.example.com
+	login bob
+	password hunter2
+default login anonymous password guest@
+`
+
+func TestNetrc(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(name, []byte(testNetrc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for host, want := range map[string]Credentials{
+		"FTP.example.com":   {"alice", `se cret"`, "billing"},
+		"other.example.com": {"bob", "hunter2", ""},
+		"unknown.example":   {"anonymous", "guest@", ""},
+	} {
+		if got, err := Netrc(name).Credentials(host); err != nil || *got != want {
+			t.Errorf("Credentials(%s) = %+v, %v, want %+v", host, got, err, want)
+		}
+	}
+
+	if _, err := parseNetrc("machine a login x", "b"); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no entry for host = %v, want ErrNoCredentials", err)
+	}
+	if _, err := parseNetrc("machine a login", "a"); err == nil {
+		t.Error("truncated netrc parsed")
+	}
+	if _, err := Netrc(filepath.Join(t.TempDir(), "missing")).Credentials("a"); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("missing netrc = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestEnvCredentials(t *testing.T) {
+	t.Setenv("FTP_USER", "default")
+	t.Setenv("FTP_PASSWORD", "pw")
+	t.Setenv("FTP_FTP_EXAMPLE_COM_USER", "special")
+
+	if got, err := Env("").Credentials("ftp.example.com"); err != nil || got.User != "special" || got.Password != "" {
+		t.Errorf("host credentials = %+v, %v", got, err)
+	}
+	if got, err := Env("").Credentials("other"); err != nil || got.User != "default" || got.Password != "pw" {
+		t.Errorf("default credentials = %+v, %v", got, err)
+	}
+	if _, err := Env("NONE").Credentials("other"); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("unset variables = %v, want ErrNoCredentials", err)
+	}
+}
+
+func TestDialLogin(t *testing.T) {
+	s := ftptest.NewUnstartedServer()
+	s.Users = map[string]string{"alice": "secret", "anonymous": AnonymousPassword}
+	s.Start()
+	defer s.Close()
+
+	var hosts []string
+	secrets := CredentialFunc(func(host string) (*Credentials, error) {
+		hosts = append(hosts, host)
+		return &Credentials{User: "alice", Password: "secret"}, nil
+	})
+	none := CredentialFunc(func(host string) (*Credentials, error) {
+		return nil, ErrNoCredentials
+	})
+	empty := CredentialFunc(func(host string) (*Credentials, error) {
+		return nil, nil
+	})
+
+	connection, err := DialLogin(s.Addr, DialCredentials(Providers(none, empty, secrets)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection.Close()
+	if len(hosts) != 1 || hosts[0] != "127.0.0.1" {
+		t.Errorf("looked up %q", hosts)
+	}
+
+	for _, p := range []CredentialProvider{none, empty} {
+		connection, err = DialURL("ftp://"+s.Addr+"/", DialCredentials(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := connection.Identity(); id.User != "anonymous" {
+			t.Errorf("logged in as %q, want anonymous", id.User)
+		}
+		connection.Close()
+	}
+
+	failing := CredentialFunc(func(host string) (*Credentials, error) {
+		return nil, errors.New("vault sealed")
+	})
+	if _, err = DialLogin(s.Addr, DialCredentials(failing)); err == nil || err.Error() != "vault sealed" {
+		t.Errorf("DialLogin with failing provider = %v", err)
+	}
+}
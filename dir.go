@@ -0,0 +1,88 @@
+package goftp
+
+import (
+	"io/fs"
+	"path"
+)
+
+// MkdirAll creates the remote directory p along with any missing parents.
+// An existing directory is not an error.
+func (ftp *FTP) MkdirAll(p string) error {
+	switch p {
+	case "", ".", "/":
+		return nil
+	}
+
+	if ok, err := ftp.isDir(p); err != nil || ok {
+		return err
+	}
+
+	if err := ftp.MkdirAll(path.Dir(p)); err != nil {
+		return err
+	}
+
+	if err := ftp.Mkd(p); err != nil {
+		// Created in the meantime by someone else
+		if ok, _ := ftp.isDir(p); ok {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// isDir reports whether p is an existing directory, with MLST or, when the
+// server lacks it, by changing into it
+func (ftp *FTP) isDir(p string) (bool, error) {
+	e, err := ftp.Mlst(p)
+	switch {
+	case err == nil:
+		return e.IsDir(), nil
+	case isNotExist(err):
+		return false, nil
+	}
+
+	pwd, err := ftp.Pwd()
+	if err != nil {
+		return false, err
+	}
+	if err = ftp.Cwd(p); err != nil {
+		return false, nil
+	}
+	return true, ftp.Cwd(pwd)
+}
+
+// RemoveAll removes the remote file or directory p along with everything
+// it contains, deepest entries first. A missing p is not an error.
+func (ftp *FTP) RemoveAll(p string) error {
+	var entries []string
+	var dirs []bool
+
+	err := ftp.WalkDir(p, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == p && d == nil && isNotExist(err) {
+				return fs.SkipAll
+			}
+			return err
+		}
+
+		entries = append(entries, name)
+		dirs = append(dirs, d.IsDir())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if dirs[i] {
+			err = ftp.Rmd(entries[i])
+		} else {
+			err = ftp.Dele(entries[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package goftp
+
+import (
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestMkdirAll(t *testing.T) {
+	for _, mlst := range []bool{true, false} {
+		s := ftptest.NewUnstartedServer()
+		s.DisableMLSD = !mlst
+		s.Start()
+		s.Mkdir("/a")
+		s.WriteFile("/file", []byte("x"))
+
+		connection := connect(t, s)
+
+		if err := connection.MkdirAll("/a/b/c"); err != nil {
+			t.Errorf("mlst=%v: %v", mlst, err)
+		}
+		if exists, isDir := s.Stat("/a/b/c"); !exists || !isDir {
+			t.Errorf("mlst=%v: /a/b/c not created", mlst)
+		}
+		if err := connection.MkdirAll("/a/b"); err != nil {
+			t.Errorf("mlst=%v: existing directory: %v", mlst, err)
+		}
+		if err := connection.MkdirAll("/file/sub"); err == nil {
+			t.Errorf("mlst=%v: directory created below a file", mlst)
+		}
+		if pwd, _ := connection.Pwd(); pwd != "/" {
+			t.Errorf("mlst=%v: working directory changed to %q", mlst, pwd)
+		}
+
+		connection.Close()
+		s.Close()
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/tree/a.txt", []byte("a"))
+	s.WriteFile("/tree/sub/b.txt", []byte("b"))
+	s.Mkdir("/tree/sub/empty")
+	s.WriteFile("/keep.txt", []byte("keep"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	if err := connection.RemoveAll("/tree"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := s.Stat("/tree"); exists {
+		t.Error("/tree not removed")
+	}
+	if exists, _ := s.Stat("/keep.txt"); !exists {
+		t.Error("/keep.txt removed")
+	}
+
+	if err := connection.RemoveAll("/missing"); err != nil {
+		t.Errorf("missing path: %v", err)
+	}
+	if err := connection.RemoveAll("/keep.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := s.Stat("/keep.txt"); exists {
+		t.Error("/keep.txt not removed")
+	}
+}
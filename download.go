@@ -0,0 +1,232 @@
+package goftp
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Conflict is the policy for a transfer whose destination already exists
+type Conflict int
+
+// Conflict policies
+const (
+	// Overwrite replaces the existing file
+	Overwrite Conflict = iota
+	// Skip leaves the existing file untouched
+	Skip
+	// Rename keeps the existing file and transfers under a free name,
+	// "name (1).ext" and so on, ext being the last extension only:
+	// "a.tar.gz" becomes "a.tar (1).gz"
+	Rename
+)
+
+// hashPrefix adds the first n bytes of the local file name to h, for
+// resumed downloads
+func hashPrefix(h hash.Hash, name string, n int64) error {
+	if n == 0 {
+		return nil
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.CopyN(h, f, n)
+	return err
+}
+
+// conflictName returns the n-th alternative of the file name name,
+// numbered before its last extension
+func conflictName(name string, n int) string {
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// DownloadOption configures Download
+type DownloadOption func(*downloader)
+
+// DownloadConflict sets what happens to local files that already exist,
+// Overwrite by default
+func DownloadConflict(policy Conflict) DownloadOption {
+	return func(d *downloader) {
+		d.conflict = policy
+	}
+}
+
+// DownloadResume continues partial downloads: a local file shorter than
+// the remote one is completed with REST instead of being transferred again.
+// A local file of the same size is considered complete.
+func DownloadResume() DownloadOption {
+	return func(d *downloader) {
+		d.resume = true
+	}
+}
+
+// DownloadVerify hashes each file while it is downloaded and compares the
+// sum with the one the server computes, using the strongest algorithm the
+// server supports. Downloads fail with ErrHashUnsupported when it supports
+// none.
+func DownloadVerify() DownloadOption {
+	return func(d *downloader) {
+		d.verify = true
+	}
+}
+
+type downloader struct {
+	ftp      *FTP
+	conflict Conflict
+	resume   bool
+	verify   bool
+}
+
+// Download a file, or recursively download a directory. The remote tree
+// rooted at remotePath is recreated at localPath, keeping modification
+// times. Only normal files and directories are downloaded.
+func (ftp *FTP) Download(remotePath, localPath string, opts ...DownloadOption) error {
+	d := &downloader{ftp: ftp}
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	type dirTime struct {
+		path string
+		t    time.Time
+	}
+	var dirs []dirTime
+
+	err := ftp.WalkDir(remotePath, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		local := filepath.Join(localPath, filepath.FromSlash(relPath(remotePath, p)))
+
+		e := entry.(entryInfo).e
+		switch e.Type {
+		case EntryTypeDir:
+			if err := os.MkdirAll(local, 0755); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTime{local, e.ModTime})
+		case EntryTypeFile:
+			return d.file(p, local, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Directory times are set last, as creating their contents changes them
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if !dirs[i].t.IsZero() {
+			os.Chtimes(dirs[i].path, dirs[i].t, dirs[i].t)
+		}
+	}
+	return nil
+}
+
+// relPath returns the path p, found walking root, relative to root
+func relPath(root, p string) string {
+	root, p = path.Clean(root), path.Clean(p)
+	switch {
+	case p == root:
+		return ""
+	case root == ".":
+		return p
+	case root == "/":
+		return strings.TrimPrefix(p, "/")
+	}
+	if rel, ok := strings.CutPrefix(p, root+"/"); ok {
+		return rel
+	}
+	return p
+}
+
+func (d *downloader) file(remote, local string, e *Entry) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	var offset int64
+
+	if fi, err := os.Stat(local); err == nil {
+		switch {
+		case fi.IsDir():
+			// Downloading a single file into an existing directory
+			local = filepath.Join(local, path.Base(remote))
+			return d.file(remote, local, e)
+		case d.resume && fi.Size() == e.Size:
+			return nil
+		case d.resume && fi.Size() < e.Size:
+			flag, offset = os.O_WRONLY|os.O_APPEND, fi.Size()
+		case d.conflict == Skip:
+			return nil
+		case d.conflict == Rename:
+			dir, name := filepath.Dir(local), filepath.Base(local)
+			for n := 1; err == nil; n++ {
+				local = filepath.Join(dir, conflictName(name, n))
+				_, err = os.Stat(local)
+			}
+		}
+	}
+
+	var algorithm HashAlgorithm
+	var h hash.Hash
+	if d.verify {
+		var err error
+		if algorithm, err = d.ftp.verifyAlgorithm(); err != nil {
+			return err
+		}
+		h = newHash(algorithm)
+		if err = hashPrefix(h, local, offset); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(local, flag, 0644)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = f
+	if h != nil {
+		w = io.MultiWriter(f, h)
+	}
+
+	if _, err = d.ftp.RetrFrom(remote, offset, func(r io.Reader) error {
+		_, err := io.Copy(w, r)
+		return err
+	}); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	if h != nil {
+		if err = d.ftp.verifyHash(remote, algorithm, h); err != nil {
+			return err
+		}
+	}
+
+	mtime := e.ModTime
+	if e.Facts["modify"] == "" {
+		// LIST times are only precise to the minute
+		if t, err := d.ftp.ModTime(remote); err == nil {
+			mtime = t
+		}
+	}
+	if mtime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(local, mtime, mtime)
+}
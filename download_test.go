@@ -0,0 +1,137 @@
+package goftp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestDownload(t *testing.T) {
+	for _, mlsd := range []bool{true, false} {
+		s := ftptest.NewUnstartedServer()
+		s.DisableMLSD = !mlsd
+		s.Start()
+
+		mtime := time.Date(2015, 8, 12, 13, 30, 45, 0, time.UTC)
+		s.WriteFile("/remote/a.txt", []byte("a"))
+		s.WriteFile("/remote/sub/b.txt", []byte("bb"))
+		s.Mkdir("/remote/empty")
+		s.Chtimes("/remote/sub/b.txt", mtime)
+
+		connection := connect(t, s)
+		local := filepath.Join(t.TempDir(), "local")
+
+		if err := connection.Download("/remote", local); err != nil {
+			t.Fatalf("mlsd=%v: %v", mlsd, err)
+		}
+
+		for name, want := range map[string]string{"a.txt": "a", "sub/b.txt": "bb"} {
+			got, err := os.ReadFile(filepath.Join(local, name))
+			if err != nil || string(got) != want {
+				t.Errorf("mlsd=%v: %s = %q, %v", mlsd, name, got, err)
+			}
+		}
+		if fi, err := os.Stat(filepath.Join(local, "empty")); err != nil || !fi.IsDir() {
+			t.Errorf("mlsd=%v: empty directory not created: %v", mlsd, err)
+		}
+		if fi, err := os.Stat(filepath.Join(local, "sub/b.txt")); err != nil || !fi.ModTime().Equal(mtime) {
+			t.Errorf("mlsd=%v: modification time not kept: %v", mlsd, fi.ModTime())
+		}
+
+		connection.Close()
+		s.Close()
+	}
+}
+
+func TestDownloadCurrentDir(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/remote/.hidden", []byte("h"))
+	s.WriteFile("/remote/.cfg/x", []byte("x"))
+	s.WriteFile("/remote/plain", []byte("p"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+	if err := connection.Cwd("/remote"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, root := range []string{".", ""} {
+		local := t.TempDir()
+		if err := connection.Download(root, local); err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range map[string]string{".hidden": "h", ".cfg/x": "x", "plain": "p"} {
+			if got, err := os.ReadFile(filepath.Join(local, filepath.FromSlash(name))); string(got) != want {
+				t.Errorf("root %q: %s = %q, %v", root, name, got, err)
+			}
+		}
+	}
+}
+
+func TestDownloadConflict(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/remote/a.txt", []byte("remote"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	if err := os.WriteFile(filepath.Join(local, "a.txt"), []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := connection.Download("/remote", local, DownloadConflict(Skip)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(local, "a.txt")); string(got) != "local" {
+		t.Errorf("Skip overwrote the local file: %q", got)
+	}
+
+	if err := connection.Download("/remote", local); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(local, "a.txt")); string(got) != "remote" {
+		t.Errorf("Overwrite kept the local file: %q", got)
+	}
+
+	if err := connection.Download("/remote", local, DownloadConflict(Rename)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(local, "a (1).txt")); string(got) != "remote" {
+		t.Errorf("Rename wrote %q to a (1).txt", got)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/big.bin", []byte("0123456789"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(local, []byte("0123"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := connection.Download("/big.bin", local, DownloadResume()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(local); string(got) != "0123456789" {
+		t.Errorf("resumed file = %q", got)
+	}
+
+	var rest bool
+	for _, cmd := range s.Commands() {
+		rest = rest || cmd == "REST 4"
+	}
+	if !rest {
+		t.Errorf("download was not resumed: %q", s.Commands())
+	}
+}
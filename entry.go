@@ -0,0 +1,317 @@
+package goftp
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EntryType is the kind of file an Entry describes
+type EntryType int
+
+// Entry types
+const (
+	EntryTypeFile EntryType = iota
+	EntryTypeDir
+	EntryTypeLink
+)
+
+// Entry is a parsed line of a directory listing. MLSD facts, Unix style and
+// DOS style LIST lines are understood.
+type Entry struct {
+	Name    string
+	Type    EntryType
+	Size    int64
+	ModTime time.Time
+
+	// Mode holds the permission bits, when the server reports them
+	Mode os.FileMode
+
+	// Target is the destination of a symbolic link, when known
+	Target string
+
+	// Facts are the raw MLSD/MLST facts, keyed by lower case fact name
+	Facts map[string]string
+}
+
+// IsDir reports whether e describes a directory
+func (e *Entry) IsDir() bool {
+	return e.Type == EntryTypeDir
+}
+
+// FileMode returns the permission bits of e along with its type bits
+func (e *Entry) FileMode() os.FileMode {
+	switch e.Type {
+	case EntryTypeDir:
+		return e.Mode | os.ModeDir
+	case EntryTypeLink:
+		return e.Mode | os.ModeSymlink
+	}
+	return e.Mode
+}
+
+// Info returns e as an fs.FileInfo
+func (e *Entry) Info() fs.FileInfo {
+	return entryInfo{e}
+}
+
+// DirEntry returns e as an fs.DirEntry
+func (e *Entry) DirEntry() fs.DirEntry {
+	return entryInfo{e}
+}
+
+// entryInfo implements fs.FileInfo and fs.DirEntry for an Entry
+type entryInfo struct {
+	e *Entry
+}
+
+func (fi entryInfo) Name() string               { return fi.e.Name }
+func (fi entryInfo) Size() int64                { return fi.e.Size }
+func (fi entryInfo) Mode() fs.FileMode          { return fi.e.FileMode() }
+func (fi entryInfo) ModTime() time.Time         { return fi.e.ModTime }
+func (fi entryInfo) IsDir() bool                { return fi.e.IsDir() }
+func (fi entryInfo) Sys() interface{}           { return fi.e }
+func (fi entryInfo) Type() fs.FileMode          { return fi.e.FileMode().Type() }
+func (fi entryInfo) Info() (fs.FileInfo, error) { return fi, nil }
+func (fi entryInfo) String() string             { return fs.FormatFileInfo(fi) }
+
+// ErrUnknownListFormat is returned for listing lines that can't be parsed
+var ErrUnknownListFormat = errors.New("unknown list format")
+
+var (
+	reUnixList = regexp.MustCompile(`^([-dlbcps])([-rwxsStT]{9})\S*\s+\d+\s+.*?\s*(\d+)\s+(\w{3})\s+(\d{1,2})\s+(\d{1,2}:\d{2}|\d{4})\s(.+)$`)
+	reDosList  = regexp.MustCompile(`^(\d{2}-\d{2}-\d{2,4})\s+(\d{1,2}:\d{2}[AaPp][Mm])\s+(<DIR>|\d+)\s+(.+)$`)
+)
+
+// ParseEntry parses a single line of an MLSD or LIST response. "." and
+// ".." entries are returned like any other, callers usually skip them.
+func ParseEntry(line string) (*Entry, error) {
+	line = strings.TrimRight(line, "\r\n")
+
+	if i := strings.IndexByte(line, ' '); i > 0 && strings.Contains(line[:i], "=") {
+		return parseFacts(line[:i], line[i+1:])
+	}
+	if m := reUnixList.FindStringSubmatch(line); m != nil {
+		return parseUnixList(m)
+	}
+	if m := reDosList.FindStringSubmatch(line); m != nil {
+		return parseDosList(m)
+	}
+	return nil, ErrUnknownListFormat
+}
+
+// parseFacts parses the "fact=value;" list of an MLSD or MLST line
+func parseFacts(facts string, name string) (*Entry, error) {
+	e := &Entry{Name: name, Facts: map[string]string{}}
+
+	for _, fact := range strings.Split(facts, ";") {
+		kv := strings.SplitN(fact, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		e.Facts[strings.ToLower(kv[0])] = kv[1]
+	}
+
+	switch t := strings.ToLower(e.Facts["type"]); {
+	case t == "dir", t == "cdir", t == "pdir":
+		e.Type = EntryTypeDir
+	case t == "file":
+		e.Type = EntryTypeFile
+	case strings.HasPrefix(t, "os.unix=slink"), strings.HasPrefix(t, "os.unix=symlink"), t == "link":
+		e.Type = EntryTypeLink
+		if i := strings.IndexByte(e.Facts["type"], ':'); i >= 0 {
+			e.Target = e.Facts["type"][i+1:]
+		}
+	default:
+		return nil, ErrUnknownListFormat
+	}
+
+	size := e.Facts["size"]
+	if size == "" {
+		size = e.Facts["sizd"]
+	}
+	if size != "" {
+		e.Size, _ = strconv.ParseInt(size, 10, 64)
+	}
+
+	if modify := e.Facts["modify"]; modify != "" {
+		e.ModTime, _ = parseTimeVal(modify)
+	}
+
+	if mode, err := strconv.ParseUint(e.Facts["unix.mode"], 8, 32); err == nil {
+		e.Mode = os.FileMode(mode).Perm()
+	} else if e.Type == EntryTypeDir {
+		e.Mode = 0755
+	} else {
+		e.Mode = 0644
+	}
+
+	return e, nil
+}
+
+// parseTimeVal parses the YYYYMMDDHHMMSS[.sss] time format of RFC 3659
+func parseTimeVal(s string) (time.Time, error) {
+	layout := "20060102150405"
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		layout += "." + strings.Repeat("0", len(s)-i-1)
+	}
+	return time.ParseInLocation(layout, s, time.UTC)
+}
+
+func parseMode(perm string) os.FileMode {
+	var mode os.FileMode
+	for i, c := range perm {
+		if c != '-' {
+			mode |= 1 << uint(8-i)
+		}
+	}
+	for i, special := range []os.FileMode{os.ModeSetuid, os.ModeSetgid, os.ModeSticky} {
+		switch perm[3*i+2] {
+		case 's', 't':
+			mode |= special
+		case 'S', 'T':
+			mode |= special
+			mode &^= 1 << uint(6-3*i)
+		}
+	}
+	return mode
+}
+
+func parseUnixList(m []string) (*Entry, error) {
+	e := &Entry{Name: m[7], Mode: parseMode(m[2])}
+
+	switch m[1] {
+	case "d":
+		e.Type = EntryTypeDir
+	case "l":
+		e.Type = EntryTypeLink
+		if i := strings.Index(e.Name, " -> "); i >= 0 {
+			e.Name, e.Target = e.Name[:i], e.Name[i+4:]
+		}
+	}
+
+	e.Size, _ = strconv.ParseInt(m[3], 10, 64)
+
+	var err error
+	if strings.Contains(m[6], ":") {
+		e.ModTime, err = parseRecentTime(m[4]+" "+m[5]+" "+m[6], time.Now().UTC())
+	} else {
+		e.ModTime, err = time.ParseInLocation("Jan 2 2006", m[4]+" "+m[5]+" "+m[6], time.UTC)
+	}
+	if err != nil {
+		return nil, ErrUnknownListFormat
+	}
+
+	return e, nil
+}
+
+// parseRecentTime parses the time of a listing without a year, which is
+// within the last six months: in the year of now, or the year before when
+// that is in the future or not a valid date, like Feb 29 in a common year
+func parseRecentTime(s string, now time.Time) (t time.Time, err error) {
+	for _, year := range []int{now.Year(), now.Year() - 1} {
+		t, err = time.ParseInLocation("Jan 2 15:04 2006", s+" "+strconv.Itoa(year), time.UTC)
+		if err == nil && !t.After(now.AddDate(0, 0, 1)) {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
+func parseDosList(m []string) (*Entry, error) {
+	e := &Entry{Name: m[4], Mode: 0644}
+
+	if m[3] == "<DIR>" {
+		e.Type, e.Mode = EntryTypeDir, 0755
+	} else {
+		e.Size, _ = strconv.ParseInt(m[3], 10, 64)
+	}
+
+	layout := "01-02-06 03:04PM"
+	if len(m[1]) == 10 {
+		layout = "01-02-2006 03:04PM"
+	}
+
+	var err error
+	if e.ModTime, err = time.ParseInLocation(layout, m[1]+" "+strings.ToUpper(m[2]), time.UTC); err != nil {
+		return nil, ErrUnknownListFormat
+	}
+	return e, nil
+}
+
+// ListEntries lists path and parses each line into an Entry. The "." and
+// ".." entries and lines that can't be parsed are left out.
+func (ftp *FTP) ListEntries(path string) (entries []*Entry, err error) {
+	var lines []string
+	if lines, err = ftp.List(path); err != nil {
+		return
+	}
+
+	for _, line := range lines {
+		e, err := ParseEntry(line)
+		if err != nil {
+			continue
+		}
+
+		if t := e.Facts["type"]; t == "cdir" || t == "pdir" || e.Name == "." || e.Name == ".." {
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Mlst returns the Entry for a single path, using MLST
+func (ftp *FTP) Mlst(p string) (*Entry, error) {
+	line, err := ftp.cmd(StatusActionOK, "MLST %s", p)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range strings.Split(line, "\n") {
+		if !strings.HasPrefix(l, " ") {
+			continue
+		}
+
+		e, err := ParseEntry(strings.TrimLeft(l, " "))
+		if err != nil {
+			return nil, err
+		}
+		e.Name = path.Base(e.Name)
+		return e, nil
+	}
+
+	return nil, errors.New(line)
+}
+
+// statEntry returns the Entry for p, using MLST or listing the parent
+// directory when the server lacks MLST
+func (ftp *FTP) statEntry(p string) (*Entry, error) {
+	e, err := ftp.Mlst(p)
+	if err == nil || isNotExist(err) {
+		return e, err
+	}
+
+	switch p {
+	case "", ".", "/":
+		return &Entry{Name: p, Type: EntryTypeDir, Mode: 0755}, nil
+	}
+
+	entries, err := ftp.ListEntries(path.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Name == path.Base(p) {
+			return e, nil
+		}
+	}
+	return nil, errors.New(StatusFileUnavailable + " " + p + ": No such file or directory")
+}
@@ -0,0 +1,99 @@
+package goftp
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseEntry(t *testing.T) {
+	tests := []struct {
+		line string
+		want Entry
+	}{
+		{
+			"type=file;size=1234;modify=20150812133000;perm=r;unix.mode=0640; some file.txt\r\n",
+			Entry{Name: "some file.txt", Type: EntryTypeFile, Size: 1234, Mode: 0640,
+				ModTime: time.Date(2015, 8, 12, 13, 30, 0, 0, time.UTC)},
+		},
+		{
+			"type=dir;modify=20150812133000.123; pub",
+			Entry{Name: "pub", Type: EntryTypeDir, Mode: 0755,
+				ModTime: time.Date(2015, 8, 12, 13, 30, 0, 123000000, time.UTC)},
+		},
+		{
+			"-rw-r--r--   22 4015     4015        17976 Jun 10  1994 COPYING",
+			Entry{Name: "COPYING", Type: EntryTypeFile, Size: 17976, Mode: 0644,
+				ModTime: time.Date(1994, 6, 10, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			"lrwxrwxrwx    1 ftp      ftp            12 Jan  1  2000 latest -> releases/1.0",
+			Entry{Name: "latest", Type: EntryTypeLink, Size: 12, Mode: 0777, Target: "releases/1.0",
+				ModTime: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			"drwxr-sr-x 2 owner 4096 Mar  3  2003 shared dir",
+			Entry{Name: "shared dir", Type: EntryTypeDir, Size: 4096, Mode: 0755 | os.ModeSetgid,
+				ModTime: time.Date(2003, 3, 3, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			"09-12-15  04:07AM             37192705 all.zip",
+			Entry{Name: "all.zip", Type: EntryTypeFile, Size: 37192705, Mode: 0644,
+				ModTime: time.Date(2015, 9, 12, 4, 7, 0, 0, time.UTC)},
+		},
+		{
+			"10-21-2014  11:30PM       <DIR>          Program Files",
+			Entry{Name: "Program Files", Type: EntryTypeDir, Mode: 0755,
+				ModTime: time.Date(2014, 10, 21, 23, 30, 0, 0, time.UTC)},
+		},
+	}
+
+	for _, test := range tests {
+		e, err := ParseEntry(test.line)
+		if err != nil {
+			t.Errorf("ParseEntry(%q): %v", test.line, err)
+			continue
+		}
+		if e.Name != test.want.Name || e.Type != test.want.Type || e.Size != test.want.Size ||
+			e.Mode != test.want.Mode || e.Target != test.want.Target || !e.ModTime.Equal(test.want.ModTime) {
+			t.Errorf("ParseEntry(%q) = %+v, want %+v", test.line, *e, test.want)
+		}
+	}
+
+	if _, err := ParseEntry("total 42"); err != ErrUnknownListFormat {
+		t.Errorf("ParseEntry(total) = %v, want ErrUnknownListFormat", err)
+	}
+}
+
+func TestParseEntryRecentYear(t *testing.T) {
+	now := time.Now().UTC()
+	recent := now.AddDate(0, -1, 0)
+
+	e, err := ParseEntry("-rw-r--r-- 1 ftp ftp 1 " + recent.Format("Jan _2 15:04") + " recent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ModTime.Year() != recent.Year() || e.ModTime.Month() != recent.Month() {
+		t.Errorf("ModTime = %v, want around %v", e.ModTime, recent)
+	}
+}
+
+func TestParseRecentTimeLeapDay(t *testing.T) {
+	for _, tt := range []struct {
+		now  time.Time
+		want string
+	}{
+		// In a common year Feb 29 is from the leap year before
+		{time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC), "2024-02-29 12:00"},
+		{time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC), "2024-02-29 12:00"},
+	} {
+		got, err := parseRecentTime("Feb 29 12:00", tt.now)
+		if err != nil || got.Format("2006-01-02 15:04") != tt.want {
+			t.Errorf("parseRecentTime(Feb 29) at %v = %v, %v, want %s", tt.now, got, err, tt.want)
+		}
+	}
+
+	if got, err := parseRecentTime("Dec 31 23:59", time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)); err != nil || got.Year() != 2024 {
+		t.Errorf("parseRecentTime(Dec 31) in January = %v, %v, want 2024", got, err)
+	}
+}
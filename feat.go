@@ -0,0 +1,74 @@
+package goftp
+
+import (
+	"errors"
+	"strings"
+)
+
+// Features returns the extensions the server advertises with FEAT, keyed by
+// upper case feature name. The value holds the parameters of the feature,
+// like the fact list of MLST. A server that doesn't understand FEAT has no
+// features. The result is cached until the next Login or AuthTLS.
+func (ftp *FTP) Features() (map[string]string, error) {
+	if ftp.features != nil {
+		return ftp.features, nil
+	}
+
+	if err := ftp.send("FEAT"); err != nil {
+		return nil, err
+	}
+
+	line, err := ftp.receive()
+	if err != nil {
+		return nil, err
+	}
+
+	features := map[string]string{}
+	switch {
+	case strings.HasPrefix(line, StatusSystemStatus):
+		features = parseFeatures(line)
+	case strings.HasPrefix(line, "5"):
+		// FEAT isn't implemented
+	default:
+		return nil, errors.New(line)
+	}
+
+	ftp.features = features
+	return features, nil
+}
+
+// parseFeatures parses a multiline 211 FEAT reply. Feature lines start with
+// a space.
+func parseFeatures(reply string) map[string]string {
+	features := map[string]string{}
+
+	for _, line := range strings.Split(reply, "\n") {
+		if !strings.HasPrefix(line, " ") {
+			continue
+		}
+
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if fields[0] == "" {
+			continue
+		}
+
+		var params string
+		if len(fields) == 2 {
+			params = fields[1]
+		}
+		features[strings.ToUpper(fields[0])] = params
+	}
+
+	return features
+}
+
+// hasFeature reports whether the server advertises feature
+func (ftp *FTP) hasFeature(feature string) bool {
+	features, err := ftp.Features()
+	if err != nil {
+		return false
+	}
+
+	_, ok := features[feature]
+	return ok
+}
@@ -0,0 +1,50 @@
+package goftp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestParseFeatures(t *testing.T) {
+	reply := "211-Features:\r\n MDTM\r\n REST STREAM\r\n MLST type*;size*;modify*;\r\n utf8\r\n211 End\r\n"
+	want := map[string]string{"MDTM": "", "REST": "STREAM", "MLST": "type*;size*;modify*;", "UTF8": ""}
+	if got := parseFeatures(reply); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseFeatures = %v, want %v", got, want)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	features, err := connection.Features()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := features["MFMT"]; !ok {
+		t.Errorf("MFMT missing from %v", features)
+	}
+
+	// Cached until the next login
+	connection.Features()
+	var feats int
+	for _, cmd := range s.Commands() {
+		if cmd == "FEAT" {
+			feats++
+		}
+	}
+	if feats != 1 {
+		t.Errorf("FEAT sent %d times", feats)
+	}
+
+	s.Reply("FEAT", 502, "Command not implemented.")
+	connection.Login("anonymous", "")
+	if features, err = connection.Features(); err != nil || len(features) != 0 {
+		t.Errorf("Features without FEAT = %v, %v", features, err)
+	}
+}
@@ -0,0 +1,187 @@
+package goftp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// FS exposes a remote directory tree as an fs.FS. It implements
+// fs.ReadDirFS, fs.StatFS and fs.ReadFileFS as well.
+//
+// An FTP session handles one command at a time, so files are read into
+// memory when opened and an FS must not be used concurrently.
+type FS struct {
+	ftp  *FTP
+	root string
+}
+
+// NewFS returns an FS for the tree rooted at root on the remote host
+func NewFS(ftp *FTP, root string) *FS {
+	return &FS{ftp: ftp, root: root}
+}
+
+func (fsys *FS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return path.Join(fsys.root, name), nil
+}
+
+// errNotDir is the error of ReadDir on a file
+var errNotDir = errors.New("not a directory")
+
+// isNotExist reports whether err is a 550 reply
+func isNotExist(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), StatusFileUnavailable)
+}
+
+func pathError(op, name string, err error) error {
+	if isNotExist(err) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Stat returns the FileInfo of name
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	p, err := fsys.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := fsys.ftp.statEntry(p)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	e.Name = path.Base(name)
+	return e.Info(), nil
+}
+
+// ReadDir lists the directory name, sorted by file name
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := fsys.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Listing a file lists the file itself
+	e, err := fsys.ftp.statEntry(p)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+	if !e.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+
+	entries, err := fsys.ftp.ListEntries(p)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+
+	list := make([]fs.DirEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, e.DirEntry())
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+// ReadFile retrieves the contents of name
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	p, err := fsys.path("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if _, err = fsys.ftp.Retr(p, func(r io.Reader) error {
+		_, err := io.Copy(&buf, r)
+		return err
+	}); err != nil {
+		return nil, pathError("readfile", name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Open opens name. Directories implement fs.ReadDirFile.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	info, err := fsys.Stat(name)
+	if err != nil {
+		if pe, ok := err.(*fs.PathError); ok {
+			pe.Op = "open"
+		}
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &fsDir{fsys: fsys, name: name, info: info}, nil
+	}
+
+	data, err := fsys.ReadFile(name)
+	if err != nil {
+		if pe, ok := err.(*fs.PathError); ok {
+			pe.Op = "open"
+		}
+		return nil, err
+	}
+	return &fsFile{Reader: bytes.NewReader(data), info: info}, nil
+}
+
+// fsFile is a regular file opened from an FS
+type fsFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Close() error               { return nil }
+
+// fsDir is a directory opened from an FS. Its entries are listed on the
+// first call to ReadDir.
+type fsDir struct {
+	fsys    *FS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	loaded  bool
+	offset  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+var _ fs.ReadDirFile = (*fsDir)(nil)
@@ -0,0 +1,41 @@
+package goftp
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestFS(t *testing.T) {
+	for _, mlsd := range []bool{true, false} {
+		s := ftptest.NewUnstartedServer()
+		s.DisableMLSD = !mlsd
+		s.Start()
+
+		s.WriteFile("/site/index.html", []byte("<html></html>"))
+		s.WriteFile("/site/css/main.css", []byte("body {}"))
+		s.WriteFile("/site/empty", nil)
+		s.Mkdir("/site/img")
+
+		connection := connect(t, s)
+		fsys := NewFS(connection, "/site")
+
+		if err := fstest.TestFS(fsys, "index.html", "css/main.css", "empty", "img"); err != nil {
+			t.Errorf("mlsd=%v: %v", mlsd, err)
+		}
+
+		if _, err := fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("mlsd=%v: Stat(missing) = %v, want fs.ErrNotExist", mlsd, err)
+		}
+		var pathErr *fs.PathError
+		if list, err := fs.ReadDir(fsys, "index.html"); !errors.As(err, &pathErr) {
+			t.Errorf("mlsd=%v: ReadDir(file) = %v, %v, want a *fs.PathError", mlsd, list, err)
+		}
+
+		connection.Close()
+		s.Close()
+	}
+}
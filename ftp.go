@@ -3,15 +3,18 @@ package goftp
 import (
 	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -29,6 +32,46 @@ type FTP struct {
 
 	reader *bufio.Reader
 	writer *bufio.Writer
+
+	// features caches the FEAT reply
+	features map[string]string
+
+	// hashAlgorithm is the algorithm selected with OPTS HASH
+	hashAlgorithm HashAlgorithm
+
+	trace    *Trace
+	observer Observer
+	// reconnect reports the dial to observer as a reconnection, see
+	// DialReconnect
+	reconnect bool
+	// sent is when the last command was sent, to time its reply, and
+	// pending its verb until the final reply
+	sent    time.Time
+	pending string
+
+	// record receives the transcript of DialRecord
+	record     io.Writer
+	recordData bool
+	recordMu   sync.Mutex
+
+	// replay plays the server in a Replay
+	replay *replayer
+
+	// identity is the login, and clientCertificate the certificate sent
+	// in the TLS handshake
+	identity          Identity
+	clientCertificate *x509.Certificate
+
+	// active selects active mode, see DialActive
+	active bool
+	// transferType is the default type of transfers, see DialTransferType
+	transferType TypeCode
+	// implicitTLS secures the control connection from the start, and
+	// urlTLS is the configuration of DialURL
+	implicitTLS *tls.Config
+	urlTLS      *tls.Config
+	// credentials is the provider of DialCredentials
+	credentials CredentialProvider
 }
 
 // Close ends the FTP connection
@@ -44,63 +87,6 @@ type (
 	RetrFunc func(r io.Reader) error
 )
 
-func parseLine(line string) (perm string, t string, filename string) {
-	for _, v := range strings.Split(line, ";") {
-		v2 := strings.Split(v, "=")
-
-		switch v2[0] {
-		case "perm":
-			perm = v2[1]
-		case "type":
-			t = v2[1]
-		default:
-			filename = v[1 : len(v)-2]
-		}
-	}
-	return
-}
-
-// Walk walks recursively through path and call walkfunc for each file
-func (ftp *FTP) Walk(path string, walkFn WalkFunc) (err error) {
-	/*
-		if err = walkFn(path, os.ModeDir, nil); err != nil {
-			if err == filepath.SkipDir {
-				return nil
-			}
-		}
-	*/
-	if ftp.debug {
-		log.Printf("Walking: '%s'\n", path)
-	}
-
-	var lines []string
-
-	if lines, err = ftp.List(path); err != nil {
-		return
-	}
-
-	for _, line := range lines {
-		_, t, subpath := parseLine(line)
-
-		switch t {
-		case "dir":
-			if subpath == "." {
-			} else if subpath == ".." {
-			} else {
-				if err = ftp.Walk(path+subpath+"/", walkFn); err != nil {
-					return
-				}
-			}
-		case "file":
-			if err = walkFn(path+subpath, os.FileMode(0), nil); err != nil {
-				return
-			}
-		}
-	}
-
-	return
-}
-
 // Quit sends quit to the server and close the connection. No need to Close after this.
 func (ftp *FTP) Quit() (err error) {
 	if _, err := ftp.cmd(StatusConnectionClosing, "QUIT"); err != nil {
@@ -122,7 +108,7 @@ func (ftp *FTP) Noop() (err error) {
 // RawCmd sends raw commands to the remote server. Returns response code as int and response as string.
 func (ftp *FTP) RawCmd(command string, args ...interface{}) (code int, line string) {
 	if ftp.debug {
-		log.Printf("Raw-> %s\n", fmt.Sprintf(command, args...))
+		log.Printf("Raw-> %s\n", redact(fmt.Sprintf(command, args...)))
 	}
 
 	code = -1
@@ -135,7 +121,7 @@ func (ftp *FTP) RawCmd(command string, args ...interface{}) (code int, line stri
 	}
 	code, err = strconv.Atoi(line[:3])
 	if ftp.debug {
-		log.Printf("Raw<-	<- %d \n", code)
+		log.Printf("Raw<- %d\n", code)
 	}
 	return code, line
 }
@@ -222,16 +208,20 @@ func (ftp *FTP) Dele(path string) (err error) {
 
 // AuthTLS secures the ftp connection by using TLS
 func (ftp *FTP) AuthTLS(config *tls.Config) error {
-	if _, err := ftp.cmd("234", "AUTH TLS"); err != nil {
+	if _, err := ftp.cmd(StatusAuthOK, "AUTH TLS"); err != nil {
 		return err
 	}
 
 	// wrap tls on existing connection
-	ftp.tlsconfig = config
+	ftp.tlsconfig = ftp.keepClientCertificate(config)
+	ftp.features = nil
+	ftp.identity.Mechanism = "TLS"
 
-	ftp.conn = tls.Client(ftp.conn, config)
-	ftp.writer = bufio.NewWriter(ftp.conn)
-	ftp.reader = bufio.NewReader(ftp.conn)
+	if ftp.replay == nil {
+		ftp.conn = tls.Client(ftp.conn, ftp.tlsconfig)
+		ftp.writer = bufio.NewWriter(ftp.conn)
+		ftp.reader = bufio.NewReader(ftp.conn)
+	}
 
 	if _, err := ftp.cmd(StatusOK, "PBSZ 0"); err != nil {
 		return err
@@ -278,16 +268,24 @@ const (
 )
 
 func (ftp *FTP) receiveLine() (string, error) {
-	line, err := ftp.reader.ReadString('\n')
+	return ftp.reader.ReadString('\n')
+}
 
-	if ftp.debug {
-		log.Printf("< %s", line)
-	}
+// receive reads a reply, discarding whatever follows it in the buffer
+func (ftp *FTP) receive() (string, error) {
+	line, err := ftp.readReply()
+	ftp.replied(line, err)
+	return line, err
+}
 
+// receiveNoDiscard reads a reply
+func (ftp *FTP) receiveNoDiscard() (string, error) {
+	line, err := ftp.readReplyNoDiscard()
+	ftp.replied(line, err)
 	return line, err
 }
 
-func (ftp *FTP) receive() (string, error) {
+func (ftp *FTP) readReply() (string, error) {
 	line, err := ftp.receiveLine()
 
 	if err != nil {
@@ -320,7 +318,7 @@ func (ftp *FTP) receive() (string, error) {
 	return line, err
 }
 
-func (ftp *FTP) receiveNoDiscard() (string, error) {
+func (ftp *FTP) readReplyNoDiscard() (string, error) {
 	line, err := ftp.receiveLine()
 
 	if err != nil {
@@ -354,22 +352,18 @@ func (ftp *FTP) receiveNoDiscard() (string, error) {
 }
 
 func (ftp *FTP) send(command string, arguments ...interface{}) error {
-	if ftp.debug {
-		log.Printf("> %s", fmt.Sprintf(command, arguments...))
-	}
-
 	command = fmt.Sprintf(command, arguments...)
-	command += "\r\n"
-
-	if _, err := ftp.writer.WriteString(command); err != nil {
-		return err
-	}
+	ftp.trace.commandSent(command)
+	ftp.recordLine(">", redact(command))
+	ftp.sent = time.Now()
 
-	if err := ftp.writer.Flush(); err != nil {
-		return err
+	_, err := ftp.writer.WriteString(command + "\r\n")
+	if err == nil {
+		err = ftp.writer.Flush()
 	}
+	ftp.sentCommand(command, err)
 
-	return nil
+	return err
 }
 
 // Pasv enables passive data connection and returns port number
@@ -381,7 +375,7 @@ func (ftp *FTP) Pasv() (port int, err error) {
 			doneChan <- 1
 		}()
 		var line string
-		if line, err = ftp.cmd("227", "PASV"); err != nil {
+		if line, err = ftp.cmd(StatusPassiveMode, "PASV"); err != nil {
 			return
 		}
 		re := regexp.MustCompile(`\((.*)\)`)
@@ -416,31 +410,47 @@ func (ftp *FTP) Pasv() (port int, err error) {
 
 // open new data connection
 func (ftp *FTP) newConnection(port int) (conn net.Conn, err error) {
-	addr := fmt.Sprintf("%s:%d", strings.Split(ftp.addr, ":")[0], port)
-
-	if ftp.debug {
-		log.Printf("Connecting to %s\n", addr)
+	addr := ftp.addr
+	start := time.Now()
+	if ftp.replay != nil {
+		conn, err = ftp.replay.dial()
+	} else {
+		var host string
+		if host, _, err = net.SplitHostPort(ftp.addr); err != nil {
+			return
+		}
+		addr = net.JoinHostPort(host, strconv.Itoa(port))
+		conn, err = net.Dial("tcp", addr)
 	}
-
-	if conn, err = net.Dial("tcp", addr); err != nil {
+	if ftp.observer != nil {
+		ftp.observer.DataConnected(addr, time.Since(start), err)
+	}
+	if err != nil {
 		return
 	}
 
-	if ftp.tlsconfig != nil {
+	return ftp.wrapData(conn), nil
+}
+
+// wrapData secures the data connection conn if needed, and wraps it for
+// the trace, observer and transcript
+func (ftp *FTP) wrapData(conn net.Conn) net.Conn {
+	if ftp.tlsconfig != nil && ftp.replay == nil {
 		conn = tls.Client(conn, ftp.tlsconfig)
 	}
-
-	return
+	return ftp.dataOpened(conn)
 }
 
-// Stor uploads file to remote host path, from r
-func (ftp *FTP) Stor(path string, r io.Reader) (err error) {
-	if err = ftp.Type(TypeImage); err != nil {
+// Stor uploads file to remote host path, from r. The transfer is binary
+// unless another TransferType is given.
+func (ftp *FTP) Stor(path string, r io.Reader, opts ...TransferOption) (err error) {
+	tr := newTransfer(ftp.transferType, opts)
+	if err = ftp.Type(tr.typ); err != nil {
 		return
 	}
 
-	var port int
-	if port, err = ftp.Pasv(); err != nil {
+	var open func() (net.Conn, error)
+	if open, err = ftp.prepareData(); err != nil {
 		return
 	}
 
@@ -449,7 +459,7 @@ func (ftp *FTP) Stor(path string, r io.Reader) (err error) {
 	}
 
 	var pconn net.Conn
-	if pconn, err = ftp.newConnection(port); err != nil {
+	if pconn, err = open(); err != nil {
 		return
 	}
 	defer pconn.Close()
@@ -464,7 +474,11 @@ func (ftp *FTP) Stor(path string, r io.Reader) (err error) {
 		return
 	}
 
-	if _, err = io.Copy(pconn, r); err != nil {
+	w := tr.writer(pconn)
+	if _, err = io.Copy(w, r); err != nil {
+		return
+	}
+	if err = w.Flush(); err != nil {
 		return
 	}
 	pconn.Close()
@@ -546,22 +560,36 @@ func (ftp *FTP) Stat(path string) ([]string, error) {
 }
 
 // Retr retrieves file from remote host at path, using retrFn to read from the remote file.
-func (ftp *FTP) Retr(path string, retrFn RetrFunc) (s string, err error) {
-	if err = ftp.Type(TypeImage); err != nil {
+// The transfer is binary unless another TransferType is given.
+func (ftp *FTP) Retr(path string, retrFn RetrFunc, opts ...TransferOption) (s string, err error) {
+	return ftp.RetrFrom(path, 0, retrFn, opts...)
+}
+
+// RetrFrom retrieves file from remote host at path, starting at offset. It
+// uses REST to resume a partial download.
+func (ftp *FTP) RetrFrom(path string, offset int64, retrFn RetrFunc, opts ...TransferOption) (s string, err error) {
+	tr := newTransfer(ftp.transferType, opts)
+	if err = ftp.Type(tr.typ); err != nil {
 		return
 	}
 
-	var port int
-	if port, err = ftp.Pasv(); err != nil {
+	var open func() (net.Conn, error)
+	if open, err = ftp.prepareData(); err != nil {
 		return
 	}
 
+	if offset > 0 {
+		if _, err = ftp.cmd(StatusActionPending, "REST %d", offset); err != nil {
+			return
+		}
+	}
+
 	if err = ftp.send("RETR %s", path); err != nil {
 		return
 	}
 
 	var pconn net.Conn
-	if pconn, err = ftp.newConnection(port); err != nil {
+	if pconn, err = open(); err != nil {
 		return
 	}
 	defer pconn.Close()
@@ -576,7 +604,7 @@ func (ftp *FTP) Retr(path string, retrFn RetrFunc) (s string, err error) {
 		return
 	}
 
-	if err = retrFn(pconn); err != nil {
+	if err = retrFn(tr.reader(pconn)); err != nil {
 		return
 	}
 
@@ -604,8 +632,8 @@ func (ftp *FTP) List(path string) (files []string, err error) {
 		return
 	}
 
-	var port int
-	if port, err = ftp.Pasv(); err != nil {
+	var open func() (net.Conn, error)
+	if open, err = ftp.prepareData(); err != nil {
 		return
 	}
 
@@ -614,7 +642,7 @@ func (ftp *FTP) List(path string) (files []string, err error) {
 	}
 
 	var pconn net.Conn
-	if pconn, err = ftp.newConnection(port); err != nil {
+	if pconn, err = open(); err != nil {
 		return
 	}
 	defer pconn.Close()
@@ -702,70 +730,132 @@ func (ftp *FTP) SmartLogin(username string, password string) (err error) {
 
 */
 
-// Login to the server with provided username and password.
-// Typical default may be ("anonymous","").
-func (ftp *FTP) Login(username string, password string) (err error) {
-	if _, err = ftp.cmd("331", "USER %s", username); err != nil {
-		if strings.HasPrefix(err.Error(), "230") {
-			// Ok, probably anonymous server
-			// but login was fine, so return no error
-			err = nil
-		} else {
-			return
-		}
-	}
+// DialOption configures Dial
+type DialOption func(*FTP)
 
-	if _, err = ftp.cmd("230", "PASS %s", password); err != nil {
-		return
+// DialTrace sets the hooks traced during the session
+func DialTrace(trace *Trace) DialOption {
+	return func(ftp *FTP) {
+		ftp.trace = trace
 	}
+}
 
-	return
+// DialLogger logs the session to logger at debug level, see SlogTrace
+func DialLogger(logger *slog.Logger) DialOption {
+	return DialTrace(SlogTrace(logger))
 }
 
-// Connect to server at addr (format "host:port"). debug is OFF
-func Connect(addr string) (*FTP, error) {
-	var err error
-	var conn net.Conn
+// DialImplicitTLS secures the control connection with config from the
+// start, as on port 990, instead of upgrading it with AuthTLS. Data
+// connections are secured too.
+func DialImplicitTLS(config *tls.Config) DialOption {
+	return func(ftp *FTP) {
+		ftp.implicitTLS = config
+	}
+}
+
+// DialTransferType sets the type of transfers made without a TransferType
+// option, TypeImage by default
+func DialTransferType(t TypeCode) DialOption {
+	return func(ftp *FTP) {
+		ftp.transferType = t
+	}
+}
 
-	if conn, err = net.Dial("tcp", addr); err != nil {
+// Dial connects to the server at addr (format "host:port") and reads its
+// greeting
+func Dial(addr string, opts ...DialOption) (*FTP, error) {
+	object := newFTP(addr, opts)
+	if err := object.dial(); err != nil {
 		return nil, err
 	}
+	return object, nil
+}
+
+func newFTP(addr string, opts []DialOption) *FTP {
+	object := &FTP{addr: addr}
+	for _, opt := range opts {
+		opt(object)
+	}
+	return object
+}
 
-	writer := bufio.NewWriter(conn)
-	reader := bufio.NewReader(conn)
+// dial connects the control connection and reads the greeting
+func (ftp *FTP) dial() error {
+	ftp.sent = time.Now()
+	conn, err := net.Dial("tcp", ftp.addr)
+	if ftp.observer != nil {
+		ftp.observer.Dialed(ftp.addr, time.Since(ftp.sent), err)
+		if ftp.reconnect && err == nil {
+			ftp.observer.Reconnected(ftp.addr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	ftp.recordLine("#", ftp.addr+" "+ftp.sent.UTC().Format(time.RFC3339))
 
-	//reader.ReadString('\n')
-	object := &FTP{conn: conn, addr: addr, reader: reader, writer: writer, debug: false}
-	object.receive()
+	if ftp.implicitTLS != nil {
+		ftp.tlsconfig = ftp.keepClientCertificate(ftp.implicitTLS)
+		ftp.identity.Mechanism = "TLS"
+		conn = tls.Client(conn, ftp.tlsconfig)
+	}
+	if err = ftp.start(conn); err != nil {
+		return err
+	}
 
-	return object, nil
+	if ftp.implicitTLS != nil {
+		if _, err = ftp.cmd(StatusOK, "PBSZ 0"); err == nil {
+			_, err = ftp.cmd(StatusOK, "PROT P")
+		}
+		if err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	return nil
 }
 
-// ConnectDbg to server at addr (format "host:port"). debug is ON
-func ConnectDbg(addr string) (*FTP, error) {
-	var err error
-	var conn net.Conn
+// start reads the greeting on the control connection conn
+func (ftp *FTP) start(conn net.Conn) error {
+	ftp.conn, ftp.reader, ftp.writer = conn, bufio.NewReader(conn), bufio.NewWriter(conn)
 
-	if conn, err = net.Dial("tcp", addr); err != nil {
-		return nil, err
+	// 120 announces a delay before the 220
+	line, err := ftp.receiveNoDiscard()
+	for err == nil && strings.HasPrefix(line, "1") {
+		line, err = ftp.receiveNoDiscard()
 	}
+	if err == nil && !strings.HasPrefix(line, StatusReady) {
+		err = errors.New(line)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	ftp.ReadAndDiscard()
 
-	writer := bufio.NewWriter(conn)
-	reader := bufio.NewReader(conn)
-
-	var line string
+	return nil
+}
 
-	object := &FTP{conn: conn, addr: addr, reader: reader, writer: writer, debug: true}
-	line, _ = object.receive()
+// Connect to server at addr (format "host:port"). debug is OFF
+func Connect(addr string) (*FTP, error) {
+	return Dial(addr)
+}
 
-	log.Print(line)
+// ConnectDbg to server at addr (format "host:port"). debug is ON
+func ConnectDbg(addr string) (*FTP, error) {
+	object, err := Dial(addr, DialTrace(debugTrace()))
+	if err != nil {
+		return nil, err
+	}
 
+	object.debug = true
 	return object, nil
 }
 
 // Size returns the size of a file.
 func (ftp *FTP) Size(path string) (size int, err error) {
-	line, err := ftp.cmd("213", "SIZE %s", path)
+	line, err := ftp.cmd(StatusFileStatus, "SIZE %s", path)
 
 	if err != nil {
 		return 0, err
@@ -774,3 +864,26 @@ func (ftp *FTP) Size(path string) (size int, err error) {
 	return strconv.Atoi(line[4 : len(line)-2])
 }
 
+// ModTime returns the modification time of a file, using MDTM.
+func (ftp *FTP) ModTime(path string) (time.Time, error) {
+	line, err := ftp.cmd(StatusFileStatus, "MDTM %s", path)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return parseTimeVal(strings.TrimSpace(line[4:]))
+}
+
+// SetModTime sets the modification time of a file. MFMT is used when the
+// server advertises it, otherwise the "MDTM <time> <path>" form some
+// servers accept.
+func (ftp *FTP) SetModTime(path string, t time.Time) error {
+	command := "MDTM"
+	if ftp.hasFeature("MFMT") {
+		command = "MFMT"
+	}
+
+	// Some servers answer 253 instead of 213
+	_, err := ftp.cmd("2", "%s %s %s", command, t.UTC().Format("20060102150405"), path)
+	return err
+}
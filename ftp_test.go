@@ -1,29 +1,21 @@
 package goftp
 
-import "testing"
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
 
-//import "fmt"
+	"github.com/dutchcoders/goftp/ftptest"
+)
 
-var goodServer string
-var uglyServer string
-var badServer string
-
-func init() {
-	//ProFTPD 1.3.5 Server (Debian)
-	goodServer = "bo.mirror.garr.it:21"
-
-	//Symantec EMEA FTP Server
-	badServer = "ftp.packardbell.com:21"
-
-	//Unknown server
-	uglyServer = "ftp.musicbrainz.org:21"
-}
-
-func standard(host string) (msg string) {
+// standard connects, logs in and lists the root of s
+func standard(s *ftptest.Server) (msg string) {
 	var err error
 	var connection *FTP
 
-	if connection, err = Connect(host); err != nil {
+	if connection, err = Connect(s.Addr); err != nil {
 		return "Can't connect ->" + err.Error()
 	}
 	if err = connection.Login("anonymous", "anonymous"); err != nil {
@@ -36,23 +28,206 @@ func standard(host string) (msg string) {
 	return ""
 }
 
+// TestLogin_good runs against a modern server with MLSD and EPSV
 func TestLogin_good(t *testing.T) {
-	str := standard(goodServer)
-	if len(str) > 0 {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	if str := standard(s); len(str) > 0 {
 		t.Error(str)
 	}
 }
 
+// TestLogin_bad runs against an old server without MLSD and EPSV
 func TestLogin_bad(t *testing.T) {
-	str := standard(badServer)
-	if len(str) > 0 {
+	s := ftptest.NewUnstartedServer()
+	s.DisableMLSD = true
+	s.DisableEPSV = true
+	s.Start()
+	defer s.Close()
+
+	if str := standard(s); len(str) > 0 {
 		t.Error(str)
 	}
 }
 
+// TestLogin_ugly runs against a slow server with an unusual greeting and
+// no features
 func TestLogin_ugly(t *testing.T) {
-	str := standard(uglyServer)
-	if len(str) > 0 {
+	s := ftptest.NewUnstartedServer()
+	s.Welcome = "Unknown server ready, no warranty"
+	s.Features = []string{}
+	s.Delay = 10 * time.Millisecond
+	s.Start()
+	defer s.Close()
+
+	if str := standard(s); len(str) > 0 {
 		t.Error(str)
 	}
 }
+
+func connect(t *testing.T, s *ftptest.Server) *FTP {
+	connection, err := Connect(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = connection.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	return connection
+}
+
+func TestStorRetr(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	if err := connection.Stor("/hello.txt", strings.NewReader("hello world")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []byte
+	if _, err := connection.Retr("/hello.txt", func(r io.Reader) (err error) {
+		got, err = ioutil.ReadAll(r)
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world" {
+		t.Errorf("Retr = %q, want %q", got, "hello world")
+	}
+}
+
+func TestList(t *testing.T) {
+	for _, mlsd := range []bool{true, false} {
+		s := ftptest.NewUnstartedServer()
+		s.DisableMLSD = !mlsd
+		s.Start()
+
+		s.WriteFile("/dir/a.txt", []byte("a"))
+		s.WriteFile("/dir/b.txt", []byte("bb"))
+
+		connection := connect(t, s)
+		lines, err := connection.List("/dir")
+		if err != nil {
+			t.Fatalf("mlsd=%v: %v", mlsd, err)
+		}
+
+		var found int
+		for _, line := range lines {
+			if strings.HasSuffix(line, " a.txt\r\n") || strings.HasSuffix(line, " b.txt\r\n") {
+				found++
+			}
+		}
+		if found != 2 {
+			t.Errorf("mlsd=%v: List = %q", mlsd, lines)
+		}
+
+		connection.Close()
+		s.Close()
+	}
+}
+
+func TestAuthTLS(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/secret.txt", []byte("secret"))
+
+	connection, err := Connect(s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.AuthTLS(s.ClientTLSConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if err = connection.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []byte
+	if _, err = connection.Retr("/secret.txt", func(r io.Reader) (err error) {
+		got, err = ioutil.ReadAll(r)
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "secret" {
+		t.Errorf("Retr = %q, want %q", got, "secret")
+	}
+}
+
+func TestFaults(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/file", []byte(strings.Repeat("x", 1<<16)))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	s.InjectFault("CWD", ftptest.Fault{Code: 450, Message: "Busy", Times: 1})
+	if err := connection.Cwd("/"); err == nil || !strings.HasPrefix(err.Error(), "450") {
+		t.Errorf("Cwd with fault = %v, want 450 reply", err)
+	}
+	if err := connection.Cwd("/"); err != nil {
+		t.Errorf("Cwd after fault = %v", err)
+	}
+
+	s.InjectFault("RETR", ftptest.Fault{DropData: true, DropAfter: 100, Times: 1})
+	if _, err := connection.Retr("/file", func(r io.Reader) error {
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	}); err == nil {
+		t.Error("Retr with dropped data connection succeeded")
+	}
+}
+
+func TestModTime(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/file", []byte("x"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	mtime := time.Date(2015, 8, 12, 13, 30, 45, 0, time.UTC)
+	if err := connection.SetModTime("/file", mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := connection.ModTime("/file"); err != nil || !got.Equal(mtime) {
+		t.Errorf("ModTime = %v, %v, want %v", got, err, mtime)
+	}
+	if cmd := s.Commands(); !strings.HasPrefix(cmd[len(cmd)-2], "MFMT ") {
+		t.Errorf("time set with %q", cmd[len(cmd)-2])
+	}
+
+	s.Reply("MDTM", 213, "20150812133045.123")
+	if got, err := connection.ModTime("/file"); err != nil || got.Nanosecond() != 123000000 {
+		t.Errorf("fractional ModTime = %v, %v", got, err)
+	}
+}
+
+func TestSetModTimeMDTM(t *testing.T) {
+	s := ftptest.NewUnstartedServer()
+	s.Features = []string{"SIZE", "MDTM"}
+	s.Start()
+	defer s.Close()
+	s.WriteFile("/file", []byte("x"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	mtime := time.Date(2015, 8, 12, 13, 30, 45, 0, time.UTC)
+	if err := connection.SetModTime("/file", mtime); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := connection.ModTime("/file"); err != nil || !got.Equal(mtime) {
+		t.Errorf("ModTime = %v, %v, want %v", got, err, mtime)
+	}
+	if cmd := s.Commands(); cmd[len(cmd)-2] != "MDTM 20150812133045 /file" {
+		t.Errorf("time set with %q", cmd[len(cmd)-2])
+	}
+}
@@ -0,0 +1,1447 @@
+// Package ftptest provides an in-memory FTP server for end-to-end tests of
+// FTP clients, in the spirit of net/http/httptest.
+package ftptest
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HandlerFunc handles a single command on a control connection. arg is
+// everything after the verb, without the line terminator.
+type HandlerFunc func(c *Conn, arg string)
+
+// Fault describes a failure injected into the handling of a command.
+type Fault struct {
+	// Code and Message, when Code is non-zero, replace the normal reply.
+	Code    int
+	Message string
+
+	// Delay is slept before the command is handled.
+	Delay time.Duration
+
+	// DropData closes the data connection after DropAfter bytes have been
+	// transferred and replies 426 instead of 226.
+	DropData  bool
+	DropAfter int
+
+	// Times limits how often the fault fires. Zero means every time.
+	Times int
+}
+
+// Server is an FTP server listening on a system-chosen port on the loopback
+// interface. The file tree is held in memory.
+type Server struct {
+	// Addr is the address of the control listener, in the form "host:port".
+	Addr string
+
+	// TLS is the server side TLS configuration used for AUTH TLS and
+	// implicit TLS. A self-signed certificate is generated when nil.
+	TLS *tls.Config
+
+	// Users maps user names to passwords. When nil every login is accepted.
+	Users map[string]string
+
+	// Accounts maps user names to the account ACCT must give after PASS.
+	// Users without an account are logged in by PASS.
+	Accounts map[string]string
+
+	// CertificateLogin requests a client certificate in TLS handshakes.
+	// USER then logs in with a 232 reply when the name is the common name
+	// of the certificate.
+	CertificateLogin bool
+
+	// Features are the lines advertised by FEAT. Defaults are used when nil.
+	Features []string
+
+	// Welcome is the text of the 220 greeting.
+	Welcome string
+
+	// Delay is slept before every reply, to simulate a slow server.
+	Delay time.Duration
+
+	// DisableMLSD makes MLSD and MLST reply 500, like pre RFC 3659 servers.
+	DisableMLSD bool
+
+	// DisableEPSV makes EPSV and EPRT reply 500.
+	DisableEPSV bool
+
+	listener net.Listener
+	implicit bool
+	cert     *x509.Certificate
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	root     *node
+	nextID   int
+	handlers map[string]HandlerFunc
+	faults   map[string][]*Fault
+	commands []string
+	conns    map[*Conn]struct{}
+}
+
+type node struct {
+	id       int
+	dir      bool
+	data     []byte
+	mode     os.FileMode
+	modTime  time.Time
+	children map[string]*node
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new Server but doesn't start it. After
+// changing its configuration, the caller should call Start or StartTLS.
+func NewUnstartedServer() *Server {
+	s := &Server{
+		Welcome:  "ftptest ready",
+		handlers: map[string]HandlerFunc{},
+		faults:   map[string][]*Fault{},
+		conns:    map[*Conn]struct{}{},
+	}
+	s.root = s.newNode(true)
+	return s
+}
+
+// Start starts a server from NewUnstartedServer. Clients may upgrade the
+// control connection with AUTH TLS.
+func (s *Server) Start() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("ftptest: failed to listen on a port: %v", err))
+	}
+	s.serve(l)
+}
+
+// StartTLS starts a server from NewUnstartedServer using implicit TLS: the
+// control connection and every data connection are encrypted from the start.
+func (s *Server) StartTLS() {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("ftptest: failed to listen on a port: %v", err))
+	}
+	s.implicit = true
+	s.serve(tls.NewListener(l, s.tlsConfig()))
+}
+
+func (s *Server) serve(l net.Listener) {
+	s.tlsConfig()
+	s.listener = l
+	s.Addr = l.Addr().String()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			c := s.newConn(conn)
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				c.serve()
+			}()
+		}
+	}()
+}
+
+// Close shuts down the server and all of its connections.
+func (s *Server) Close() {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+
+	s.mu.Lock()
+	for c := range s.conns {
+		c.close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// Certificate returns the certificate used by the server, or nil when TLS
+// was configured by the caller.
+func (s *Server) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// ClientTLSConfig returns a client configuration that trusts the server's
+// generated certificate.
+func (s *Server) ClientTLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	if s.cert != nil {
+		pool.AddCert(s.cert)
+	}
+
+	return &tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+}
+
+func (s *Server) tlsConfig() *tls.Config {
+	if s.TLS != nil {
+		return s.TLS
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("ftptest: generating key: %v", err))
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"ftptest"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		DNSNames:              []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(fmt.Sprintf("ftptest: creating certificate: %v", err))
+	}
+
+	if s.cert, err = x509.ParseCertificate(der); err != nil {
+		panic(fmt.Sprintf("ftptest: parsing certificate: %v", err))
+	}
+
+	s.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	if s.CertificateLogin {
+		s.TLS.ClientAuth = tls.RequestClientCert
+	}
+	return s.TLS
+}
+
+// Handle replaces the built-in handling of verb with h.
+func (s *Server) Handle(verb string, h HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[strings.ToUpper(verb)] = h
+}
+
+// Reply makes the server answer every verb command with a fixed reply.
+func (s *Server) Reply(verb string, code int, message string) {
+	s.Handle(verb, func(c *Conn, arg string) {
+		c.Reply(code, "%s", message)
+	})
+}
+
+// InjectFault registers f to fire on verb. Faults for the same verb fire
+// in the order they were injected.
+func (s *Server) InjectFault(verb string, f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	verb = strings.ToUpper(verb)
+	s.faults[verb] = append(s.faults[verb], &f)
+}
+
+// ClearFaults removes all injected faults.
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = map[string][]*Fault{}
+}
+
+func (s *Server) fault(verb string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	faults := s.faults[verb]
+	if len(faults) == 0 {
+		return nil
+	}
+
+	f := *faults[0]
+	if faults[0].Times > 0 {
+		faults[0].Times--
+		if faults[0].Times == 0 {
+			s.faults[verb] = faults[1:]
+		}
+	}
+	return &f
+}
+
+// Commands returns every command received so far, across all connections.
+func (s *Server) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.commands...)
+}
+
+func (s *Server) newNode(dir bool) *node {
+	s.nextID++
+
+	n := &node{id: s.nextID, dir: dir, modTime: time.Now().UTC().Truncate(time.Second)}
+	if dir {
+		n.mode = os.ModeDir | 0755
+		n.children = map[string]*node{}
+	} else {
+		n.mode = 0644
+	}
+	return n
+}
+
+func splitPath(name string) []string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return nil
+	}
+	return strings.Split(name[1:], "/")
+}
+
+// lookup must be called with s.mu held.
+func (s *Server) lookup(name string) *node {
+	n := s.root
+	for _, elem := range splitPath(name) {
+		if !n.dir {
+			return nil
+		}
+		if n = n.children[elem]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// parent returns the directory holding name and the base name of name.
+// It must be called with s.mu held.
+func (s *Server) parent(name string) (*node, string) {
+	elems := splitPath(name)
+	if len(elems) == 0 {
+		return nil, ""
+	}
+
+	dir := s.lookup(strings.Join(elems[:len(elems)-1], "/"))
+	if dir == nil || !dir.dir {
+		return nil, ""
+	}
+	return dir, elems[len(elems)-1]
+}
+
+// mkdirAll must be called with s.mu held.
+func (s *Server) mkdirAll(name string) (*node, error) {
+	n := s.root
+	for _, elem := range splitPath(name) {
+		child := n.children[elem]
+		if child == nil {
+			child = s.newNode(true)
+			n.children[elem] = child
+		} else if !child.dir {
+			return nil, fmt.Errorf("ftptest: %s is not a directory", elem)
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// Mkdir creates the directory name along with any missing parents.
+func (s *Server) Mkdir(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.mkdirAll(name)
+	return err
+}
+
+// WriteFile creates or replaces the file name with data, creating missing
+// parent directories.
+func (s *Server) WriteFile(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.mkdirAll(path.Dir(path.Clean("/" + name)))
+	if err != nil {
+		return err
+	}
+
+	base := path.Base(path.Clean("/" + name))
+	if n := dir.children[base]; n != nil && n.dir {
+		return fmt.Errorf("ftptest: %s is a directory", name)
+	}
+
+	n := s.newNode(false)
+	n.data = append([]byte(nil), data...)
+	dir.children[base] = n
+	return nil
+}
+
+// Link makes name refer to the existing file or directory target, like a
+// symbolic link followed by the server. Both report the same unique fact.
+// Linking a directory below itself creates a loop.
+func (s *Server) Link(name, target string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.lookup(target)
+	if n == nil {
+		return os.ErrNotExist
+	}
+
+	dir, base := s.parent(name)
+	if dir == nil {
+		return os.ErrNotExist
+	}
+	dir.children[base] = n
+	return nil
+}
+
+// ReadFile returns the contents of the file name.
+func (s *Server) ReadFile(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.lookup(name)
+	if n == nil {
+		return nil, os.ErrNotExist
+	}
+	if n.dir {
+		return nil, fmt.Errorf("ftptest: %s is a directory", name)
+	}
+	return append([]byte(nil), n.data...), nil
+}
+
+// Stat reports whether name exists and whether it is a directory.
+func (s *Server) Stat(name string) (exists bool, isDir bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.lookup(name)
+	if n == nil {
+		return false, false
+	}
+	return true, n.dir
+}
+
+// Chtimes sets the modification time of name.
+func (s *Server) Chtimes(name string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.lookup(name)
+	if n == nil {
+		return os.ErrNotExist
+	}
+	n.modTime = t.UTC()
+	return nil
+}
+
+// Conn is the server side of a control connection.
+type Conn struct {
+	s *Server
+
+	mu     sync.Mutex
+	ctrl   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	user     string
+	loggedIn bool
+	passed   bool
+	dir      string
+	secure   bool
+	protect  bool
+
+	pasv       net.Listener
+	active     string
+	restart    int64
+	renameFrom string
+	fault      *Fault
+	hash       string
+}
+
+func (s *Server) newConn(conn net.Conn) *Conn {
+	c := &Conn{
+		s:       s,
+		ctrl:    conn,
+		reader:  bufio.NewReader(conn),
+		writer:  bufio.NewWriter(conn),
+		dir:     "/",
+		hash:    "SHA-256",
+		secure:  s.implicit,
+		protect: s.implicit,
+	}
+
+	s.mu.Lock()
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	return c
+}
+
+// User returns the name given with USER.
+func (c *Conn) User() string {
+	return c.user
+}
+
+// Dir returns the current working directory of the connection.
+func (c *Conn) Dir() string {
+	return c.dir
+}
+
+// Reply writes a single line reply to the client.
+func (c *Conn) Reply(code int, format string, args ...interface{}) {
+	c.ReplyLines(code, fmt.Sprintf(format, args...))
+}
+
+// ReplyLines writes a reply to the client, using the multiline format when
+// more than one line is given.
+func (c *Conn) ReplyLines(code int, lines ...string) {
+	if c.s.Delay > 0 {
+		time.Sleep(c.s.Delay)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, line := range lines {
+		switch {
+		case i == len(lines)-1:
+			fmt.Fprintf(c.writer, "%d %s\r\n", code, line)
+		case i == 0:
+			fmt.Fprintf(c.writer, "%d-%s\r\n", code, line)
+		default:
+			fmt.Fprintf(c.writer, " %s\r\n", line)
+		}
+	}
+	c.writer.Flush()
+}
+
+// certificateName returns the common name of the client certificate, if
+// any.
+func (c *Conn) certificateName() string {
+	c.mu.Lock()
+	conn, ok := c.ctrl.(*tls.Conn)
+	c.mu.Unlock()
+	if !ok {
+		return ""
+	}
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		return certs[0].Subject.CommonName
+	}
+	return ""
+}
+
+func (c *Conn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ctrl.Close()
+}
+
+func (c *Conn) serve() {
+	defer func() {
+		c.close()
+		if c.pasv != nil {
+			c.pasv.Close()
+		}
+
+		c.s.mu.Lock()
+		delete(c.s.conns, c)
+		c.s.mu.Unlock()
+	}()
+
+	c.Reply(220, "%s", c.s.Welcome)
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+		verb = strings.ToUpper(verb)
+
+		c.s.mu.Lock()
+		c.s.commands = append(c.s.commands, line)
+		h := c.s.handlers[verb]
+		c.s.mu.Unlock()
+
+		c.fault = nil
+		if f := c.s.fault(verb); f != nil {
+			if f.Delay > 0 {
+				time.Sleep(f.Delay)
+			}
+			if f.Code != 0 {
+				c.Reply(f.Code, "%s", f.Message)
+				continue
+			}
+			if f.DropData {
+				c.fault = f
+			}
+		}
+
+		if h != nil {
+			h(c, arg)
+			continue
+		}
+
+		if verb == "QUIT" {
+			c.Reply(221, "Goodbye.")
+			return
+		}
+
+		c.handle(verb, arg)
+	}
+}
+
+// resolve returns the absolute path of name relative to the working directory.
+func (c *Conn) resolve(name string) string {
+	if path.IsAbs(name) {
+		return path.Clean(name)
+	}
+	return path.Join(c.dir, name)
+}
+
+// listArg strips ls style options some clients pass to LIST.
+func listArg(arg string) string {
+	var fields []string
+	for _, f := range strings.Fields(arg) {
+		if !strings.HasPrefix(f, "-") {
+			fields = append(fields, f)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+var preLogin = map[string]bool{
+	"USER": true, "PASS": true, "ACCT": true, "AUTH": true, "PBSZ": true, "PROT": true,
+	"FEAT": true, "SYST": true, "NOOP": true, "OPTS": true,
+}
+
+func (c *Conn) handle(verb, arg string) {
+	if !c.loggedIn && !preLogin[verb] {
+		c.Reply(530, "Please login with USER and PASS.")
+		return
+	}
+
+	switch verb {
+	case "USER":
+		c.user, c.loggedIn, c.passed = arg, false, false
+		if c.s.CertificateLogin && c.certificateName() == arg {
+			c.loggedIn = true
+			c.Reply(232, "User %s logged in, authorized by certificate.", arg)
+			return
+		}
+		c.Reply(331, "Please specify the password.")
+	case "PASS":
+		if c.s.Users != nil {
+			if password, ok := c.s.Users[c.user]; !ok || password != arg {
+				c.Reply(530, "Login incorrect.")
+				return
+			}
+		}
+		if _, ok := c.s.Accounts[c.user]; ok {
+			c.passed = true
+			c.Reply(332, "Need account for login.")
+			return
+		}
+		c.loggedIn = true
+		c.Reply(230, "Login successful.")
+	case "ACCT":
+		if !c.passed {
+			c.Reply(503, "Login with USER and PASS first.")
+			return
+		}
+		if c.s.Accounts[c.user] != arg {
+			c.Reply(530, "Login incorrect.")
+			return
+		}
+		c.loggedIn = true
+		c.Reply(230, "Login successful.")
+	case "AUTH":
+		if strings.ToUpper(arg) != "TLS" && strings.ToUpper(arg) != "SSL" {
+			c.Reply(504, "Unsupported security mechanism.")
+			return
+		}
+		if c.secure {
+			c.Reply(503, "Already using TLS.")
+			return
+		}
+		c.Reply(234, "Proceed with negotiation.")
+
+		c.mu.Lock()
+		c.ctrl = tls.Server(c.ctrl, c.s.tlsConfig())
+		c.reader = bufio.NewReader(c.ctrl)
+		c.writer = bufio.NewWriter(c.ctrl)
+		c.mu.Unlock()
+		c.secure = true
+	case "PBSZ":
+		c.Reply(200, "PBSZ=0")
+	case "PROT":
+		switch {
+		case !c.secure:
+			c.Reply(503, "PROT needs a secure connection.")
+		case strings.ToUpper(arg) == "P":
+			c.protect = true
+			c.Reply(200, "Protection level set to P")
+		case strings.ToUpper(arg) == "C":
+			c.protect = false
+			c.Reply(200, "Protection level set to C")
+		default:
+			c.Reply(504, "Unsupported protection level.")
+		}
+	case "FEAT":
+		features := c.s.Features
+		if features == nil {
+			features = []string{"SIZE", "MDTM", "MFMT", "REST STREAM", "UTF8", "AUTH TLS", "PBSZ", "PROT",
+				"HASH SHA-256*;SHA-1;MD5;CRC32"}
+			if !c.s.DisableMLSD {
+				features = append(features, "MLST type*;size*;modify*;perm*;unique*;")
+			}
+			if !c.s.DisableEPSV {
+				features = append(features, "EPSV")
+			}
+		}
+		c.ReplyLines(211, append(append([]string{"Features:"}, features...), "End")...)
+	case "OPTS":
+		fields := strings.Fields(arg)
+		if len(fields) == 0 || strings.ToUpper(fields[0]) != "HASH" {
+			c.Reply(200, "OK")
+			return
+		}
+		if len(fields) > 1 {
+			if _, ok := digest(strings.ToUpper(fields[1]), nil); !ok {
+				c.Reply(501, "Unknown algorithm.")
+				return
+			}
+			c.hash = strings.ToUpper(fields[1])
+		}
+		c.Reply(200, "%s", c.hash)
+	case "SYST":
+		c.Reply(215, "UNIX Type: L8")
+	case "NOOP":
+		c.Reply(200, "NOOP ok.")
+	case "TYPE", "MODE", "STRU":
+		c.Reply(200, "OK")
+	case "PWD", "XPWD":
+		c.Reply(257, "\"%s\" is the current directory", strings.Replace(c.dir, "\"", "\"\"", -1))
+	case "CWD", "XCWD":
+		c.cwd(c.resolve(arg))
+	case "CDUP", "XCUP":
+		c.cwd(path.Dir(c.dir))
+	case "MKD", "XMKD":
+		c.mkd(c.resolve(arg))
+	case "RMD", "XRMD":
+		c.remove(c.resolve(arg), true)
+	case "DELE":
+		c.remove(c.resolve(arg), false)
+	case "RNFR":
+		c.s.mu.Lock()
+		n := c.s.lookup(c.resolve(arg))
+		c.s.mu.Unlock()
+		if n == nil {
+			c.Reply(550, "RNFR command failed.")
+			return
+		}
+		c.renameFrom = c.resolve(arg)
+		c.Reply(350, "Ready for RNTO.")
+	case "RNTO":
+		c.rename(c.resolve(arg))
+	case "SIZE":
+		c.s.mu.Lock()
+		n := c.s.lookup(c.resolve(arg))
+		c.s.mu.Unlock()
+		if n == nil || n.dir {
+			c.Reply(550, "Could not get file size.")
+			return
+		}
+		c.Reply(213, "%d", len(n.data))
+	case "HASH", "XCRC", "XMD5", "XSHA1", "XSHA256", "XSHA512":
+		c.s.mu.Lock()
+		n := c.s.lookup(c.resolve(arg))
+		var data []byte
+		if n != nil {
+			data = n.data
+		}
+		c.s.mu.Unlock()
+		if n == nil || n.dir {
+			c.Reply(550, "Could not compute hash.")
+			return
+		}
+		if verb != "HASH" {
+			sum, _ := digest(legacyHashes[verb], data)
+			c.Reply(250, "%s", sum)
+			return
+		}
+		sum, _ := digest(c.hash, data)
+		c.Reply(213, "%s 0-%d %s %s", c.hash, len(data), sum, arg)
+	case "MDTM":
+		c.s.mu.Lock()
+		n := c.s.lookup(c.resolve(arg))
+		c.s.mu.Unlock()
+		if n == nil {
+			// MDTM <time> <path>, the setting form some servers accept
+			if fields := strings.SplitN(arg, " ", 2); len(fields) == 2 {
+				if t, err := time.Parse("20060102150405", fields[0]); err == nil {
+					c.setModTime(fields[1], t)
+					return
+				}
+			}
+			c.Reply(550, "Could not get file modification time.")
+			return
+		}
+		c.Reply(213, "%s", n.modTime.Format("20060102150405"))
+	case "SITE":
+		fields := strings.SplitN(arg, " ", 3)
+		switch strings.ToUpper(fields[0]) {
+		case "HELP":
+			c.ReplyLines(214, "The following SITE commands are recognized", "CHMOD", "HELP", "Help OK.")
+		case "CHMOD":
+			var mode uint32
+			if len(fields) != 3 {
+				c.Reply(501, "SITE CHMOD needs 2 arguments.")
+				return
+			}
+			if _, err := fmt.Sscanf(fields[1], "%o", &mode); err != nil || mode > 07777 {
+				c.Reply(501, "Bad mode.")
+				return
+			}
+			c.s.mu.Lock()
+			n := c.s.lookup(c.resolve(fields[2]))
+			if n != nil {
+				n.mode = n.mode&os.ModeDir | os.FileMode(mode).Perm()
+			}
+			c.s.mu.Unlock()
+			if n == nil {
+				c.Reply(550, "SITE CHMOD command failed.")
+				return
+			}
+			c.Reply(200, "SITE CHMOD command ok.")
+		default:
+			c.Reply(500, "Unknown SITE command.")
+		}
+	case "MFMT":
+		fields := strings.SplitN(arg, " ", 2)
+		if len(fields) != 2 {
+			c.Reply(501, "Syntax error in parameters or arguments.")
+			return
+		}
+		t, err := time.Parse("20060102150405", strings.SplitN(fields[0], ".", 2)[0])
+		if err != nil {
+			c.Reply(501, "Bad time value.")
+			return
+		}
+		c.setModTime(fields[1], t)
+	case "REST":
+		var offset int64
+		if _, err := fmt.Sscanf(arg, "%d", &offset); err != nil || offset < 0 {
+			c.Reply(501, "Bad restart offset.")
+			return
+		}
+		c.restart = offset
+		c.Reply(350, "Restart position accepted (%d).", offset)
+	case "PASV":
+		c.passive(false)
+	case "EPSV":
+		if c.s.DisableEPSV {
+			c.Reply(500, "Unknown command.")
+			return
+		}
+		c.passive(true)
+	case "PORT":
+		c.port(arg)
+	case "EPRT":
+		if c.s.DisableEPSV {
+			c.Reply(500, "Unknown command.")
+			return
+		}
+		c.eprt(arg)
+	case "LIST", "NLST":
+		c.list(c.resolve(listArg(arg)), verb == "NLST")
+	case "MLSD":
+		if c.s.DisableMLSD {
+			c.Reply(500, "Unknown command.")
+			return
+		}
+		c.mlsd(c.resolve(arg))
+	case "MLST":
+		if c.s.DisableMLSD {
+			c.Reply(500, "Unknown command.")
+			return
+		}
+		c.mlst(c.resolve(arg))
+	case "STAT":
+		c.stat(arg)
+	case "ABOR":
+		// Commands are handled one at a time, there is never a transfer
+		// in progress to abort
+		c.closeData()
+		c.Reply(225, "No transfer to ABOR.")
+	case "RETR":
+		c.retr(c.resolve(arg))
+	case "STOR", "APPE":
+		c.stor(c.resolve(arg), verb == "APPE")
+	default:
+		c.Reply(502, "Command not implemented.")
+	}
+}
+
+func (c *Conn) cwd(name string) {
+	c.s.mu.Lock()
+	n := c.s.lookup(name)
+	c.s.mu.Unlock()
+
+	if n == nil || !n.dir {
+		c.Reply(550, "Failed to change directory.")
+		return
+	}
+	c.dir = name
+	c.Reply(250, "Directory successfully changed.")
+}
+
+func (c *Conn) mkd(name string) {
+	c.s.mu.Lock()
+	dir, base := c.s.parent(name)
+	ok := dir != nil && dir.children[base] == nil
+	if ok {
+		dir.children[base] = c.s.newNode(true)
+	}
+	c.s.mu.Unlock()
+
+	if !ok {
+		c.Reply(550, "Create directory operation failed.")
+		return
+	}
+	c.Reply(257, "\"%s\" created", name)
+}
+
+func (c *Conn) remove(name string, isDir bool) {
+	c.s.mu.Lock()
+	dir, base := c.s.parent(name)
+	var n *node
+	if dir != nil {
+		n = dir.children[base]
+	}
+	ok := n != nil && n.dir == isDir && (!isDir || len(n.children) == 0)
+	if ok {
+		delete(dir.children, base)
+	}
+	c.s.mu.Unlock()
+
+	if !ok {
+		c.Reply(550, "Remove operation failed.")
+		return
+	}
+	c.Reply(250, "Remove operation successful.")
+}
+
+func (c *Conn) rename(to string) {
+	from := c.renameFrom
+	c.renameFrom = ""
+	if from == "" {
+		c.Reply(503, "RNFR required first.")
+		return
+	}
+
+	c.s.mu.Lock()
+	srcDir, srcBase := c.s.parent(from)
+	dstDir, dstBase := c.s.parent(to)
+	ok := srcDir != nil && dstDir != nil && srcDir.children[srcBase] != nil &&
+		!strings.HasPrefix(to+"/", from+"/")
+	if ok {
+		if existing := dstDir.children[dstBase]; existing != nil && existing.dir {
+			ok = false
+		}
+	}
+	if ok {
+		n := srcDir.children[srcBase]
+		delete(srcDir.children, srcBase)
+		dstDir.children[dstBase] = n
+	}
+	c.s.mu.Unlock()
+
+	if !ok {
+		c.Reply(550, "Rename failed.")
+		return
+	}
+	c.Reply(250, "Rename successful.")
+}
+
+// closeData drops the data connection negotiated with PASV, EPSV, PORT or
+// EPRT.
+func (c *Conn) closeData() {
+	if c.pasv != nil {
+		c.pasv.Close()
+		c.pasv = nil
+	}
+	c.active = ""
+}
+
+// dropData accepts the pending passive data connection and closes it, for
+// a failed transfer. Clients dial after sending the command, so closing
+// the listener right away could refuse them before they read the reply.
+func (c *Conn) dropData() {
+	if l, ok := c.pasv.(*net.TCPListener); ok {
+		l.SetDeadline(time.Now().Add(time.Second))
+		if conn, err := l.Accept(); err == nil {
+			conn.Close()
+		}
+	}
+	c.closeData()
+}
+
+func (c *Conn) passive(extended bool) {
+	c.closeData()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		c.Reply(425, "Cannot open passive connection.")
+		return
+	}
+	c.pasv = l
+
+	port := l.Addr().(*net.TCPAddr).Port
+	if extended {
+		c.Reply(229, "Entering Extended Passive Mode (|||%d|)", port)
+		return
+	}
+	c.Reply(227, "Entering Passive Mode (127,0,0,1,%d,%d).", port>>8, port&0xff)
+}
+
+func (c *Conn) port(arg string) {
+	var h [4]int
+	var p1, p2 int
+	if _, err := fmt.Sscanf(arg, "%d,%d,%d,%d,%d,%d", &h[0], &h[1], &h[2], &h[3], &p1, &p2); err != nil {
+		c.Reply(501, "Illegal PORT command.")
+		return
+	}
+	c.setActive(net.JoinHostPort(fmt.Sprintf("%d.%d.%d.%d", h[0], h[1], h[2], h[3]), fmt.Sprint(p1<<8+p2)))
+	c.Reply(200, "PORT command successful.")
+}
+
+func (c *Conn) eprt(arg string) {
+	if len(arg) < 2 {
+		c.Reply(501, "Illegal EPRT command.")
+		return
+	}
+
+	fields := strings.Split(arg, arg[:1])
+	if len(fields) != 5 {
+		c.Reply(501, "Illegal EPRT command.")
+		return
+	}
+	c.setActive(net.JoinHostPort(fields[2], fields[3]))
+	c.Reply(200, "EPRT command successful.")
+}
+
+func (c *Conn) setActive(addr string) {
+	c.closeData()
+	c.active = addr
+}
+
+// openData establishes the data connection negotiated with PASV, EPSV,
+// PORT or EPRT.
+func (c *Conn) openData() (net.Conn, error) {
+	var conn net.Conn
+	var err error
+
+	switch {
+	case c.pasv != nil:
+		l := c.pasv
+		c.pasv = nil
+		defer l.Close()
+
+		l.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+		conn, err = l.Accept()
+	case c.active != "":
+		addr := c.active
+		c.active = ""
+
+		conn, err = net.DialTimeout("tcp", addr, 10*time.Second)
+	default:
+		err = errors.New("no data connection negotiated")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.protect {
+		conn = tls.Server(conn, c.s.tlsConfig())
+	}
+	return conn, nil
+}
+
+// Transfer opens the data connection, replies 150, calls fn and replies
+// 226 or 426 depending on the result. It lets custom handlers serve
+// scripted listings or file contents.
+func (c *Conn) Transfer(fn func(data net.Conn) error) {
+	conn, err := c.openData()
+	if err != nil {
+		c.Reply(425, "Can't open data connection.")
+		return
+	}
+
+	c.Reply(150, "Opening data connection.")
+
+	if c.fault != nil {
+		conn = &dropConn{Conn: conn, remaining: c.fault.DropAfter}
+	}
+
+	err = fn(conn)
+	if cerr := conn.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		c.Reply(426, "Connection closed; transfer aborted.")
+		return
+	}
+	c.Reply(226, "Transfer complete.")
+}
+
+var errDropped = errors.New("ftptest: data connection dropped")
+
+// dropConn fails a transfer after a number of bytes.
+type dropConn struct {
+	net.Conn
+	remaining int
+}
+
+func (d *dropConn) Read(p []byte) (int, error) {
+	if d.remaining <= 0 {
+		return 0, errDropped
+	}
+	if len(p) > d.remaining {
+		p = p[:d.remaining]
+	}
+	n, err := d.Conn.Read(p)
+	d.remaining -= n
+	return n, err
+}
+
+func (d *dropConn) Write(p []byte) (int, error) {
+	if len(p) > d.remaining {
+		n, _ := d.Conn.Write(p[:d.remaining])
+		d.remaining -= n
+		return n, errDropped
+	}
+	n, err := d.Conn.Write(p)
+	d.remaining -= n
+	return n, err
+}
+
+type entry struct {
+	name string
+	n    *node
+}
+
+// entries returns the listing of name: its children when it is a
+// directory, or the file itself.
+func (c *Conn) entries(name string) ([]entry, bool) {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+
+	n := c.s.lookup(name)
+	if n == nil {
+		return nil, false
+	}
+	if !n.dir {
+		return []entry{{path.Base(name), n}}, true
+	}
+
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := make([]entry, 0, len(names))
+	for _, name := range names {
+		list = append(list, entry{name, n.children[name]})
+	}
+	return list, true
+}
+
+func listLine(e entry) string {
+	t := e.n.modTime
+	stamp := t.Format("Jan _2  2006")
+	if time.Since(t) < 180*24*time.Hour && time.Until(t) < time.Hour {
+		stamp = t.Format("Jan _2 15:04")
+	}
+
+	mode := e.n.mode.String()
+	if e.n.dir {
+		mode = "d" + mode[1:]
+	}
+	return fmt.Sprintf("%s 1 ftp ftp %12d %s %s", mode, len(e.n.data), stamp, e.name)
+}
+
+func facts(n *node, kind string) string {
+	perm := "adfrw"
+	if n.dir {
+		perm = "flcdmpe"
+	}
+	if kind == "" {
+		kind = "file"
+		if n.dir {
+			kind = "dir"
+		}
+	}
+	return fmt.Sprintf("type=%s;size=%d;modify=%s;perm=%s;unix.mode=0%o;unique=%x;",
+		kind, len(n.data), n.modTime.Format("20060102150405"), perm, n.mode.Perm(), n.id)
+}
+
+func (c *Conn) list(name string, namesOnly bool) {
+	list, ok := c.entries(name)
+	if !ok {
+		c.Reply(550, "No such file or directory.")
+		return
+	}
+
+	c.Transfer(func(data net.Conn) error {
+		for _, e := range list {
+			line := e.name
+			if !namesOnly {
+				line = listLine(e)
+			}
+			if _, err := fmt.Fprintf(data, "%s\r\n", line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Conn) mlsd(name string) {
+	c.s.mu.Lock()
+	dir := c.s.lookup(name)
+	var parent *node
+	if name != "/" {
+		parent = c.s.lookup(path.Dir(name))
+	}
+	c.s.mu.Unlock()
+
+	if dir == nil || !dir.dir {
+		c.Reply(550, "Not a directory.")
+		return
+	}
+	list, _ := c.entries(name)
+
+	c.Transfer(func(data net.Conn) error {
+		lines := []string{facts(dir, "cdir") + " ."}
+		if parent != nil {
+			lines = append(lines, facts(parent, "pdir")+" ..")
+		}
+		for _, e := range list {
+			lines = append(lines, facts(e.n, "")+" "+e.name)
+		}
+		for _, line := range lines {
+			if _, err := fmt.Fprintf(data, "%s\r\n", line); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (c *Conn) mlst(name string) {
+	c.s.mu.Lock()
+	n := c.s.lookup(name)
+	c.s.mu.Unlock()
+
+	if n == nil {
+		c.Reply(550, "No such file or directory.")
+		return
+	}
+	c.ReplyLines(250, "Listing "+name, facts(n, "")+" "+name, "End")
+}
+
+func (c *Conn) stat(arg string) {
+	if arg == "" {
+		c.ReplyLines(211, "ftptest status:", "Logged in as "+c.user, "End of status")
+		return
+	}
+
+	name := c.resolve(listArg(arg))
+	list, ok := c.entries(name)
+	if !ok {
+		c.Reply(550, "No such file or directory.")
+		return
+	}
+
+	lines := []string{"Status of " + name + ":"}
+	for _, e := range list {
+		lines = append(lines, listLine(e))
+	}
+	code := 213
+	if exists, isDir := c.s.Stat(name); exists && isDir {
+		code = 212
+	}
+	c.ReplyLines(code, append(lines, "End of status")...)
+}
+
+func (c *Conn) retr(name string) {
+	offset := c.restart
+	c.restart = 0
+
+	c.s.mu.Lock()
+	n := c.s.lookup(name)
+	var data []byte
+	if n != nil && !n.dir {
+		data = n.data
+	}
+	c.s.mu.Unlock()
+
+	if n == nil || n.dir {
+		// Drop the data connection, so that a server waiting on it in a
+		// server-to-server transfer sees it closed
+		c.dropData()
+		c.Reply(550, "Failed to open file.")
+		return
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	c.Transfer(func(conn net.Conn) error {
+		_, err := conn.Write(data[offset:])
+		return err
+	})
+}
+
+var legacyHashes = map[string]string{
+	"XCRC": "CRC32", "XMD5": "MD5", "XSHA1": "SHA-1", "XSHA256": "SHA-256", "XSHA512": "SHA-512",
+}
+
+// digest returns the hex digest of data with the HASH algorithm name.
+func digest(algorithm string, data []byte) (string, bool) {
+	var h hash.Hash
+	switch algorithm {
+	case "CRC32":
+		h = crc32.NewIEEE()
+	case "MD5":
+		h = md5.New()
+	case "SHA-1":
+		h = sha1.New()
+	case "SHA-256":
+		h = sha256.New()
+	case "SHA-512":
+		h = sha512.New()
+	default:
+		return "", false
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+func (c *Conn) setModTime(name string, t time.Time) {
+	c.s.mu.Lock()
+	n := c.s.lookup(c.resolve(name))
+	if n != nil {
+		n.modTime = t
+	}
+	c.s.mu.Unlock()
+
+	if n == nil {
+		c.Reply(550, "Could not set file modification time.")
+		return
+	}
+	c.Reply(213, "Modify=%s; %s", t.Format("20060102150405"), name)
+}
+
+func (c *Conn) stor(name string, appendMode bool) {
+	offset := c.restart
+	c.restart = 0
+
+	c.s.mu.Lock()
+	dir, base := c.s.parent(name)
+	var existing []byte
+	ok := dir != nil
+	if ok {
+		if n := dir.children[base]; n != nil {
+			ok = !n.dir
+			existing = n.data
+		}
+	}
+	c.s.mu.Unlock()
+
+	if !ok {
+		c.dropData()
+		c.Reply(553, "Could not create file.")
+		return
+	}
+
+	var prefix []byte
+	switch {
+	case appendMode:
+		prefix = existing
+	case offset > 0:
+		if offset > int64(len(existing)) {
+			offset = int64(len(existing))
+		}
+		prefix = existing[:offset]
+	}
+
+	c.Transfer(func(conn net.Conn) error {
+		buf := append([]byte(nil), prefix...)
+		chunk := make([]byte, 32*1024)
+		for {
+			n, err := conn.Read(chunk)
+			buf = append(buf, chunk[:n]...)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+		}
+
+		c.s.mu.Lock()
+		defer c.s.mu.Unlock()
+
+		n := c.s.newNode(false)
+		if old := dir.children[base]; old != nil {
+			n.id, n.mode = old.id, old.mode
+		}
+		n.data = buf
+		dir.children[base] = n
+		return nil
+	})
+}
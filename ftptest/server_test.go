@@ -0,0 +1,146 @@
+package ftptest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func dial(t *testing.T, conn net.Conn) *textproto.Conn {
+	c := textproto.NewConn(conn)
+	if _, _, err := c.ReadResponse(220); err != nil {
+		t.Fatal(err)
+	}
+	for _, cmd := range []string{"USER anonymous", "PASS anonymous"} {
+		if _, err := c.Cmd("%s", cmd); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := c.ReadResponse(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func expect(t *testing.T, c *textproto.Conn, code int, format string, args ...interface{}) string {
+	if _, err := c.Cmd(format, args...); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := c.ReadResponse(code)
+	if err != nil {
+		t.Fatalf("%s: %v", fmt.Sprintf(format, args...), err)
+	}
+	return msg
+}
+
+func TestImplicitTLS(t *testing.T) {
+	s := NewUnstartedServer()
+	s.StartTLS()
+	defer s.Close()
+
+	conn, err := tls.Dial("tcp", s.Addr, s.ClientTLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, conn)
+	defer c.Close()
+
+	expect(t, c, 200, "NOOP")
+}
+
+func TestActiveMode(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFile("/a/file.txt", []byte("contents"))
+
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, conn)
+	defer c.Close()
+
+	for _, eprt := range []bool{false, true} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := l.Addr().(*net.TCPAddr).Port
+
+		if eprt {
+			expect(t, c, 200, "EPRT |1|127.0.0.1|%d|", port)
+		} else {
+			expect(t, c, 200, "PORT 127,0,0,1,%d,%d", port>>8, port&0xff)
+		}
+		expect(t, c, 150, "RETR /a/file.txt")
+
+		data, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(data)
+		data.Close()
+		l.Close()
+
+		if _, _, err := c.ReadResponse(226); err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "contents" {
+			t.Errorf("eprt=%v: RETR = %q", eprt, got)
+		}
+	}
+}
+
+func TestExtendedPassive(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.WriteFile("/a/file.txt", []byte("contents"))
+
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, conn)
+	defer c.Close()
+
+	msg := expect(t, c, 229, "EPSV")
+	var port int
+	if _, err := fmt.Sscanf(msg[strings.Index(msg, "(|||"):], "(|||%d|)", &port); err != nil {
+		t.Fatalf("EPSV reply %q: %v", msg, err)
+	}
+
+	data, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, c, 150, "NLST /a")
+	got, _ := ioutil.ReadAll(data)
+	data.Close()
+	if _, _, err := c.ReadResponse(226); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "file.txt\r\n" {
+		t.Errorf("NLST = %q", got)
+	}
+}
+
+func TestScriptedReply(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, conn)
+	defer c.Close()
+
+	s.Reply("SITE", 200, "scripted")
+	if msg := expect(t, c, 200, "SITE anything"); msg != "scripted" {
+		t.Errorf("SITE = %q, want scripted reply", msg)
+	}
+}
@@ -0,0 +1,106 @@
+package goftp
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var rePasvAddr = regexp.MustCompile(`(\d+,\d+,\d+,\d+,\d+,\d+)`)
+
+// ErrFXPProtected is returned by FXP when a session protects its data
+// connections with TLS
+var ErrFXPProtected = errors.New("FXP with protected data connections is not supported")
+
+// FXP copies the file srcPath on src to dstPath on dst with a server to
+// server transfer: src is put in passive mode, dst connects to it with
+// PORT, and the data never passes through this host. Both sessions must be
+// logged in, and both servers must allow data connections with a host other
+// than the client, which many refuse by default. Encrypted data connections
+// are not supported, ErrFXPProtected being returned for sessions secured
+// with TLS. Errors from both sides are reported.
+func FXP(src *FTP, srcPath string, dst *FTP, dstPath string) error {
+	// Secured sessions always set PROT P
+	if src.tlsconfig != nil || dst.tlsconfig != nil {
+		return ErrFXPProtected
+	}
+
+	if err := src.Type(TypeImage); err != nil {
+		return err
+	}
+	if err := dst.Type(TypeImage); err != nil {
+		return err
+	}
+
+	line, err := src.cmd(StatusPassiveMode, "PASV")
+	if err != nil {
+		return err
+	}
+	addr := rePasvAddr.FindString(line)
+	if addr == "" {
+		return errors.New("PasvBadAnswer")
+	}
+
+	if _, err = dst.cmd(StatusOK, "PORT %s", addr); err != nil {
+		return err
+	}
+
+	// The destination connects first, the source then accepts and sends.
+	// The final replies may arrive along with the preliminary ones, so the
+	// buffered input is kept.
+	if err = dst.send("STOR %s", dstPath); err != nil {
+		return err
+	}
+	if line, err = dst.receiveNoDiscard(); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "1") {
+		return errors.New(line)
+	}
+
+	if err = src.send("RETR %s", srcPath); err != nil {
+		return err
+	}
+	if line, err = src.receiveNoDiscard(); err == nil && !strings.HasPrefix(line, "1") {
+		err = errors.New(line)
+	}
+	if err != nil {
+		// Remove whatever part of the file the destination kept
+		dst.abort()
+		dst.Dele(dstPath)
+		return fmt.Errorf("source: %w", err)
+	}
+
+	var errs []error
+	if line, err = src.receive(); err == nil && !strings.HasPrefix(line, StatusClosingDataConnection) {
+		err = errors.New(line)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("source: %w", err))
+	}
+
+	if line, err = dst.receive(); err == nil && !strings.HasPrefix(line, StatusClosingDataConnection) {
+		err = errors.New(line)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("destination: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// abort stops the transfer in progress with ABOR. The server sends the
+// final reply of the transfer, then the reply to ABOR.
+func (ftp *FTP) abort() error {
+	if err := ftp.send("ABOR"); err != nil {
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := ftp.receiveNoDiscard(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package goftp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestFXP(t *testing.T) {
+	srcServer, dstServer := ftptest.NewServer(), ftptest.NewServer()
+	defer srcServer.Close()
+	defer dstServer.Close()
+	data := strings.Repeat("fxp", 1<<16)
+	srcServer.WriteFile("/file", []byte(data))
+	dstServer.Mkdir("/in")
+
+	src, dst := connect(t, srcServer), connect(t, dstServer)
+	defer src.Close()
+	defer dst.Close()
+
+	if err := FXP(src, "/file", dst, "/in/file"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := dstServer.ReadFile("/in/file"); string(got) != data {
+		t.Errorf("transferred %d bytes, want %d", len(got), len(data))
+	}
+
+	// Failures on either side leave both sessions usable
+	if err := FXP(src, "/missing", dst, "/in/other"); err == nil || !strings.Contains(err.Error(), "source: 550") {
+		t.Errorf("missing source error = %v", err)
+	}
+	if exists, _ := dstServer.Stat("/in/other"); exists {
+		t.Error("failed transfer left a file on the destination")
+	}
+	if err := FXP(src, "/file", dst, "/nodir/file"); err == nil || !strings.HasPrefix(err.Error(), "553") {
+		t.Errorf("refused destination error = %v", err)
+	}
+	for _, connection := range []*FTP{src, dst} {
+		if _, err := connection.Pwd(); err != nil {
+			t.Errorf("session unusable after failed transfer: %v", err)
+		}
+	}
+
+	if err := FXP(src, "/file", dst, "/in/again"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFXPProtected(t *testing.T) {
+	srcServer, dstServer := ftptest.NewServer(), ftptest.NewServer()
+	defer srcServer.Close()
+	defer dstServer.Close()
+
+	src, dst := connect(t, srcServer), connect(t, dstServer)
+	defer src.Close()
+	defer dst.Close()
+
+	if err := src.AuthTLS(srcServer.ClientTLSConfig()); err != nil {
+		t.Fatal(err)
+	}
+	if err := FXP(src, "/file", dst, "/file"); err != ErrFXPProtected {
+		t.Errorf("FXP from a protected session = %v, want ErrFXPProtected", err)
+	}
+	for _, cmd := range srcServer.Commands() {
+		if cmd == "PASV" {
+			t.Error("transfer started")
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package goftp
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// GlobOption configures Glob
+type GlobOption func(*globber)
+
+// GlobFiles leaves directories out of the matches of Glob
+func GlobFiles() GlobOption {
+	return func(g *globber) {
+		g.filesOnly = true
+	}
+}
+
+// Glob returns the remote paths matching pattern, with the syntax of
+// path.Match plus "**" as a whole element matching any number of
+// directories, none included, or as the last element everything below the
+// directory, not the directory itself. Only the directories the pattern
+// leads to are listed, each once. The matches are sorted, and relative to
+// the current directory when pattern is. The only error of a malformed
+// pattern is path.ErrBadPattern.
+//
+// Like WalkDir, "**" does not descend into a directory with the unique fact
+// of one of its ancestors, which breaks symlink loops on servers that
+// support MLSD.
+func (ftp *FTP) Glob(pattern string, opts ...GlobOption) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	g := &globber{
+		ftp:       ftp,
+		listings:  map[string][]*Entry{},
+		ancestors: map[string]bool{},
+		matches:   map[string]bool{},
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	dir, rest := "", path.Clean(pattern)
+	if path.IsAbs(rest) {
+		dir, rest = "/", rest[1:]
+	}
+	if rest == "" {
+		// The root itself
+		g.add(dir, nil)
+	} else if err := g.glob(dir, strings.Split(rest, "/"), true); err != nil {
+		return nil, err
+	}
+
+	matches := make([]string, 0, len(g.matches))
+	for p := range g.matches {
+		matches = append(matches, p)
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+type globber struct {
+	ftp       *FTP
+	filesOnly bool
+
+	// listings caches the directories listed, by path
+	listings map[string][]*Entry
+
+	// ancestors holds the unique facts of the directories "**" is in
+	ancestors map[string]bool
+	matches   map[string]bool
+}
+
+// add adds the match p, whose entry e is nil for a directory
+func (g *globber) add(p string, e *Entry) {
+	if g.filesOnly && (e == nil || e.IsDir()) {
+		return
+	}
+	g.matches[p] = true
+}
+
+// glob adds the paths matching elems, which are not empty, under dir, which
+// is known to be a directory when isDir is set
+func (g *globber) glob(dir string, elems []string, isDir bool) error {
+	elem, rest := elems[0], elems[1:]
+
+	// Literal elements are followed without listing
+	if elem != "**" && !strings.ContainsAny(elem, `*?[\`) {
+		p := joinPath(dir, elem)
+		if len(rest) > 0 {
+			return g.glob(p, rest, false)
+		}
+		return g.exists(dir, p)
+	}
+
+	entries, ok, err := g.list(dir, isDir)
+	if err != nil || !ok {
+		return err
+	}
+
+	if elem == "**" {
+		// Matching no directory
+		if len(rest) > 0 {
+			if err := g.glob(dir, rest, true); err != nil {
+				return err
+			}
+		}
+
+		for _, e := range entries {
+			p := joinPath(dir, e.Name)
+			if len(rest) == 0 {
+				g.add(p, e)
+			}
+			if !e.IsDir() {
+				continue
+			}
+			unique := e.Facts["unique"]
+			if unique != "" {
+				if g.ancestors[unique] {
+					continue
+				}
+				g.ancestors[unique] = true
+			}
+			err := g.glob(p, elems, true)
+			delete(g.ancestors, unique)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, e := range entries {
+		if matched, _ := path.Match(elem, e.Name); !matched {
+			continue
+		}
+		p := joinPath(dir, e.Name)
+		if len(rest) == 0 {
+			g.add(p, e)
+		} else if e.IsDir() {
+			if err := g.glob(p, rest, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exists adds p, in dir, when it exists
+func (g *globber) exists(dir, p string) error {
+	if entries, ok := g.listings[dir]; ok {
+		for _, e := range entries {
+			if e.Name == path.Base(p) {
+				g.add(p, e)
+			}
+		}
+		return nil
+	}
+
+	e, err := g.ftp.statEntry(p)
+	switch {
+	case isNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	g.add(p, e)
+	return nil
+}
+
+// list returns the entries of dir, and false when it is not a directory.
+// A directory reached by literal elements is checked first, as listing a
+// file lists the file.
+func (g *globber) list(dir string, isDir bool) ([]*Entry, bool, error) {
+	if entries, ok := g.listings[dir]; ok {
+		return entries, true, nil
+	}
+
+	if !isDir {
+		e, err := g.ftp.statEntry(dir)
+		switch {
+		case isNotExist(err):
+			return nil, false, nil
+		case err != nil:
+			return nil, false, err
+		case !e.IsDir():
+			return nil, false, nil
+		}
+	}
+
+	p := dir
+	if p == "" {
+		p = "."
+	}
+	entries, err := g.ftp.ListEntries(p)
+	switch {
+	case isNotExist(err):
+		return nil, false, nil
+	case err != nil:
+		return nil, false, err
+	}
+	g.listings[dir] = entries
+	return entries, true, nil
+}
+
+// joinPath joins name to dir, which is empty for the current directory
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return path.Join(dir, name)
+}
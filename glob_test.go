@@ -0,0 +1,110 @@
+package goftp
+
+import (
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestGlob(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/incoming/2025-12/old.csv", []byte("o"))
+	s.WriteFile("/incoming/2026-01/a.csv", []byte("a"))
+	s.WriteFile("/incoming/2026-01/notes.txt", []byte("n"))
+	s.WriteFile("/incoming/2026-02/deep/er/b.csv", []byte("b"))
+	s.WriteFile("/incoming/readme.csv", []byte("r"))
+	s.WriteFile("/other/c.csv", []byte("c"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	for _, tt := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"/incoming/2026-*/*.csv", []string{"/incoming/2026-01/a.csv"}},
+		{"/incoming/2026-*/**/*.csv", []string{"/incoming/2026-01/a.csv", "/incoming/2026-02/deep/er/b.csv"}},
+		{"/incoming/**/deep", []string{"/incoming/2026-02/deep"}},
+		{"/incoming/2026-02/**", []string{"/incoming/2026-02/deep", "/incoming/2026-02/deep/er", "/incoming/2026-02/deep/er/b.csv"}},
+		{"/incoming/2026-01/a.csv", []string{"/incoming/2026-01/a.csv"}},
+		{"/incoming/2026-01/a.csv/*", []string{}},
+		{"/incoming/missing/*.csv", []string{}},
+		{"/*/c.csv", []string{"/other/c.csv"}},
+	} {
+		got, err := connection.Glob(tt.pattern)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Glob(%s) = %q, %v, want %q", tt.pattern, got, err, tt.want)
+		}
+	}
+
+	if _, err := connection.Glob("/incoming/[a"); err != path.ErrBadPattern {
+		t.Errorf("bad pattern = %v", err)
+	}
+
+	if err := connection.Cwd("/incoming"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := connection.Glob("2026-0?/*.csv"); err != nil || !reflect.DeepEqual(got, []string{"2026-01/a.csv"}) {
+		t.Errorf("relative Glob = %q, %v", got, err)
+	}
+	if err := connection.Cwd("/incoming/2026-02"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := connection.Glob("**"); err != nil || !reflect.DeepEqual(got, []string{"deep", "deep/er", "deep/er/b.csv"}) {
+		t.Errorf("Glob(**) = %q, %v", got, err)
+	}
+	if got, err := connection.Glob("**", GlobFiles()); err != nil || !reflect.DeepEqual(got, []string{"deep/er/b.csv"}) {
+		t.Errorf("Glob(**, GlobFiles()) = %q, %v", got, err)
+	}
+	if got, err := connection.Glob("/incoming/*", GlobFiles()); err != nil || !reflect.DeepEqual(got, []string{"/incoming/readme.csv"}) {
+		t.Errorf("Glob(/incoming/*, GlobFiles()) = %q, %v", got, err)
+	}
+}
+
+func TestGlobSharedLinks(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/shared/x.csv", []byte("x"))
+	s.Mkdir("/top")
+	s.Link("/top/a", "/shared")
+	s.Link("/top/b", "/shared")
+	s.Link("/shared/up", "/top")
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	got, err := connection.Glob("/top/**/*.csv")
+	if want := []string{"/top/a/x.csv", "/top/b/x.csv"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Glob = %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestGlobListsOnlyNeededDirectories(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/incoming/2025-12/x/old.csv", []byte("o"))
+	s.WriteFile("/incoming/2026-01/x/a.csv", []byte("a"))
+	s.WriteFile("/archive/b.csv", []byte("b"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	if _, err := connection.Glob("/incoming/2026-*/**/*.csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []string
+	for _, c := range s.Commands() {
+		if verb, arg, _ := strings.Cut(c, " "); verb == "MLSD" || verb == "LIST" {
+			listed = append(listed, arg)
+		}
+	}
+	want := []string{"/incoming", "/incoming/2026-01", "/incoming/2026-01/x"}
+	if !reflect.DeepEqual(listed, want) {
+		t.Errorf("listed %q, want %q", listed, want)
+	}
+}
@@ -0,0 +1,193 @@
+package goftp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+)
+
+// HashAlgorithm names a hash function the way the HASH command does
+type HashAlgorithm string
+
+// Hash algorithms
+const (
+	HashCRC32  HashAlgorithm = "CRC32"
+	HashMD5    HashAlgorithm = "MD5"
+	HashSHA1   HashAlgorithm = "SHA-1"
+	HashSHA256 HashAlgorithm = "SHA-256"
+	HashSHA512 HashAlgorithm = "SHA-512"
+)
+
+// hashCommands are the legacy commands computing a single algorithm
+var hashCommands = map[HashAlgorithm]string{
+	HashCRC32:  "XCRC",
+	HashMD5:    "XMD5",
+	HashSHA1:   "XSHA1",
+	HashSHA256: "XSHA256",
+	HashSHA512: "XSHA512",
+}
+
+// hashPreference lists the algorithms strongest first
+var hashPreference = []HashAlgorithm{HashSHA512, HashSHA256, HashSHA1, HashMD5, HashCRC32}
+
+// ErrHashUnsupported is returned when the server can't compute a hash with
+// the requested algorithm
+var ErrHashUnsupported = errors.New("hash algorithm not supported by the server")
+
+// ErrChecksumMismatch is returned when a verified transfer doesn't match
+// the server's checksum
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// HashAlgorithms returns the hash algorithms the server advertises in
+// FEAT, through HASH or the legacy XCRC, XMD5, XSHA1, XSHA256 and XSHA512
+// commands, strongest first
+func (ftp *FTP) HashAlgorithms() []HashAlgorithm {
+	features, err := ftp.Features()
+	if err != nil {
+		return nil
+	}
+
+	var algorithms []HashAlgorithm
+	for _, algorithm := range hashPreference {
+		if hashListed(features, algorithm) {
+			algorithms = append(algorithms, algorithm)
+		} else if _, ok := features[hashCommands[algorithm]]; ok {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+	return algorithms
+}
+
+// hashListed reports whether the HASH feature lists algorithm. The
+// algorithm currently selected is marked with a "*".
+func hashListed(features map[string]string, algorithm HashAlgorithm) bool {
+	for _, listed := range strings.Split(features["HASH"], ";") {
+		if strings.EqualFold(strings.TrimSuffix(listed, "*"), string(algorithm)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Hash asks the server for the checksum of the file at path, returned as
+// lower case hex. HASH is used when the server lists algorithm for it,
+// otherwise the matching legacy command.
+func (ftp *FTP) Hash(path string, algorithm HashAlgorithm) (string, error) {
+	features, err := ftp.Features()
+	if err != nil {
+		return "", err
+	}
+
+	if hashListed(features, algorithm) {
+		if ftp.hashAlgorithm != algorithm {
+			if _, err = ftp.cmd(StatusOK, "OPTS HASH %s", algorithm); err != nil {
+				return "", err
+			}
+			ftp.hashAlgorithm = algorithm
+		}
+
+		// 213 <algorithm> <start>-<end> <hash> <path>
+		line, err := ftp.cmd(StatusFileStatus, "HASH %s", path)
+		if err != nil {
+			return "", err
+		}
+		if fields := strings.Fields(line[4:]); len(fields) >= 3 && isHex(fields[2]) {
+			return strings.ToLower(fields[2]), nil
+		}
+		return "", errors.New(line)
+	}
+
+	command := hashCommands[algorithm]
+	if _, ok := features[command]; !ok {
+		return "", ErrHashUnsupported
+	}
+
+	// Replies differ between servers, the hash is the first hex field
+	line, err := ftp.cmd("2", "%s %s", command, path)
+	if err != nil {
+		return "", err
+	}
+	for _, field := range strings.Fields(line[4:]) {
+		if isHex(field) {
+			return strings.ToLower(field), nil
+		}
+	}
+	return "", errors.New(line)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// newHash returns a local implementation of algorithm
+func newHash(algorithm HashAlgorithm) hash.Hash {
+	switch algorithm {
+	case HashCRC32:
+		return crc32.NewIEEE()
+	case HashMD5:
+		return md5.New()
+	case HashSHA1:
+		return sha1.New()
+	case HashSHA512:
+		return sha512.New()
+	}
+	return sha256.New()
+}
+
+// hashFile returns the hex checksum of the local file name
+func hashFile(name string, algorithm HashAlgorithm) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := newHash(algorithm)
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sameSum compares hex checksums. Some servers leave out the leading zeros
+// of CRC32 sums.
+func sameSum(a, b string) bool {
+	return strings.EqualFold(strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0"))
+}
+
+// verifyAlgorithm returns the strongest algorithm the server can hash with
+func (ftp *FTP) verifyAlgorithm() (HashAlgorithm, error) {
+	algorithms := ftp.HashAlgorithms()
+	if len(algorithms) == 0 {
+		return "", ErrHashUnsupported
+	}
+	return algorithms[0], nil
+}
+
+// verifyHash compares the local checksum h of a transfer with the server's
+// checksum of path
+func (ftp *FTP) verifyHash(path string, algorithm HashAlgorithm, h hash.Hash) error {
+	sum, err := ftp.Hash(path, algorithm)
+	if err != nil {
+		return err
+	}
+
+	if local := hex.EncodeToString(h.Sum(nil)); !sameSum(sum, local) {
+		return fmt.Errorf("%w: %s of %s is %s on the server, %s locally", ErrChecksumMismatch, algorithm, path, sum, local)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package goftp
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestHash(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/file", []byte("The quick brown fox jumps over the lazy dog"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	if got, want := connection.HashAlgorithms(), []HashAlgorithm{HashSHA256, HashSHA1, HashMD5, HashCRC32}; !reflect.DeepEqual(got, want) {
+		t.Errorf("HashAlgorithms = %v, want %v", got, want)
+	}
+
+	for algorithm, want := range map[HashAlgorithm]string{
+		HashCRC32:  "414fa339",
+		HashMD5:    "9e107d9d372bb6826bd81d3542a419d6",
+		HashSHA1:   "2fd4e1c67a2d28fced849ee1bb76e7391b93eb12",
+		HashSHA256: "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592",
+	} {
+		if got, err := connection.Hash("/file", algorithm); err != nil || got != want {
+			t.Errorf("Hash %s = %q, %v, want %q", algorithm, got, err, want)
+		}
+	}
+
+	if _, err := connection.Hash("/file", HashSHA512); err != ErrHashUnsupported {
+		t.Errorf("Hash SHA-512 error = %v", err)
+	}
+}
+
+func TestHashLegacy(t *testing.T) {
+	s := ftptest.NewUnstartedServer()
+	s.Features = []string{"SIZE", "XCRC", "XMD5"}
+	s.Start()
+	defer s.Close()
+	s.WriteFile("/file", []byte("The quick brown fox jumps over the lazy dog"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	if got, want := connection.HashAlgorithms(), []HashAlgorithm{HashMD5, HashCRC32}; !reflect.DeepEqual(got, want) {
+		t.Errorf("HashAlgorithms = %v, want %v", got, want)
+	}
+	if got, err := connection.Hash("/file", HashMD5); err != nil || got != "9e107d9d372bb6826bd81d3542a419d6" {
+		t.Errorf("Hash MD5 = %q, %v", got, err)
+	}
+	if cmd := s.Commands(); cmd[len(cmd)-1] != "XMD5 /file" {
+		t.Errorf("hashed with %q", cmd[len(cmd)-1])
+	}
+}
+
+func TestTransferVerify(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/remote/a.txt", []byte("0123456789"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"up.txt": "upload"}, time.Now())
+
+	if err := connection.Upload(filepath.Join(local, "up.txt"), UploadTo("/remote"), UploadVerify()); err != nil {
+		t.Error(err)
+	}
+
+	// A resumed download hashes the part already there
+	if err := os.WriteFile(filepath.Join(local, "a.txt"), []byte("0123"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := connection.Download("/remote/a.txt", filepath.Join(local, "a.txt"), DownloadResume(), DownloadVerify()); err != nil {
+		t.Error(err)
+	}
+
+	s.Reply("HASH", 213, "SHA-256 0-10 00ff a.txt")
+	if err := connection.Upload(filepath.Join(local, "up.txt"), UploadTo("/remote"), UploadVerify()); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("upload mismatch error = %v", err)
+	}
+	if err := connection.Download("/remote/a.txt", filepath.Join(local, "b.txt"), DownloadVerify()); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("download mismatch error = %v", err)
+	}
+}
+
+func TestTransferVerifyUnsupported(t *testing.T) {
+	s := ftptest.NewUnstartedServer()
+	s.Features = []string{"SIZE"}
+	s.Start()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"up.txt": "upload"}, time.Now())
+
+	if err := connection.Upload(filepath.Join(local, "up.txt"), UploadVerify()); err != ErrHashUnsupported {
+		t.Errorf("Upload error = %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package goftp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricBuckets are the upper bounds of the latency histograms, in seconds
+var metricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(metricBuckets))
+	}
+
+	v := d.Seconds()
+	for i, bound := range metricBuckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Metrics is an Observer aggregating the activity of any number of sessions
+// into counters and histograms. It serves them in the Prometheus text
+// exposition format:
+//
+//	metrics := goftp.NewMetrics()
+//	http.Handle("/metrics", metrics)
+//	ftp, err := goftp.Dial(addr, goftp.DialObserver(metrics))
+type Metrics struct {
+	mu sync.Mutex
+
+	dials, dialErrors uint64
+	reconnects        uint64
+	commands          map[string]*histogram
+	replies           map[int]uint64
+	commandErrors     map[string]uint64
+	dataConnect       histogram
+	dataConnectErrors uint64
+	transferBytes     map[string]uint64
+	transfers         map[string]uint64
+}
+
+// NewMetrics returns an empty Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		commands:      map[string]*histogram{},
+		replies:       map[int]uint64{},
+		commandErrors: map[string]uint64{},
+		transferBytes: map[string]uint64{},
+		transfers:     map[string]uint64{},
+	}
+}
+
+// Dialed implements Observer
+func (m *Metrics) Dialed(addr string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dials++
+	if err != nil {
+		m.dialErrors++
+	}
+}
+
+// Reconnected implements Observer
+func (m *Metrics) Reconnected(addr string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reconnects++
+}
+
+// CommandDone implements Observer
+func (m *Metrics) CommandDone(verb string, code int, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := m.commands[verb]
+	if h == nil {
+		h = &histogram{}
+		m.commands[verb] = h
+	}
+	h.observe(elapsed)
+
+	if code == 0 {
+		m.commandErrors[verb]++
+	} else {
+		m.replies[code]++
+	}
+}
+
+// DataConnected implements Observer
+func (m *Metrics) DataConnected(addr string, elapsed time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err != nil {
+		m.dataConnectErrors++
+		return
+	}
+	m.dataConnect.observe(elapsed)
+}
+
+// TransferDone implements Observer
+func (m *Metrics) TransferDone(verb string, bytes int64, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.transfers[verb]++
+	m.transferBytes[verb] += uint64(bytes)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+
+	header := func(name, typ, help string) {
+		fmt.Fprintf(cw, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	header("ftp_dials_total", "counter", "Control connections dialed, reconnections included.")
+	fmt.Fprintf(cw, "ftp_dials_total %d\n", m.dials)
+	header("ftp_dial_errors_total", "counter", "Control connections that failed.")
+	fmt.Fprintf(cw, "ftp_dial_errors_total %d\n", m.dialErrors)
+	header("ftp_reconnects_total", "counter", "Control connections set up again after one was lost.")
+	fmt.Fprintf(cw, "ftp_reconnects_total %d\n", m.reconnects)
+
+	header("ftp_command_duration_seconds", "histogram", "Time from sending a command to its final reply.")
+	for _, verb := range sortedKeys(m.commands) {
+		writeHistogram(cw, "ftp_command_duration_seconds", "verb="+labelValue(verb)+",", m.commands[verb])
+	}
+
+	header("ftp_replies_total", "counter", "Final replies by reply code.")
+	codes := make([]int, 0, len(m.replies))
+	for code := range m.replies {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(cw, "ftp_replies_total{code=\"%d\"} %d\n", code, m.replies[code])
+	}
+
+	header("ftp_command_errors_total", "counter", "Commands that failed without a reply.")
+	for _, verb := range sortedKeys(m.commandErrors) {
+		fmt.Fprintf(cw, "ftp_command_errors_total{verb=%s} %d\n", labelValue(verb), m.commandErrors[verb])
+	}
+
+	header("ftp_data_connect_duration_seconds", "histogram", "Time to set up data connections.")
+	writeHistogram(cw, "ftp_data_connect_duration_seconds", "", &m.dataConnect)
+	header("ftp_data_connect_errors_total", "counter", "Data connections that failed.")
+	fmt.Fprintf(cw, "ftp_data_connect_errors_total %d\n", m.dataConnectErrors)
+
+	header("ftp_transfers_total", "counter", "Data transfers by command.")
+	for _, verb := range sortedKeys(m.transfers) {
+		fmt.Fprintf(cw, "ftp_transfers_total{verb=%s} %d\n", labelValue(verb), m.transfers[verb])
+	}
+	header("ftp_transfer_bytes_total", "counter", "Bytes carried by data connections, by command.")
+	for _, verb := range sortedKeys(m.transferBytes) {
+		fmt.Fprintf(cw, "ftp_transfer_bytes_total{verb=%s} %d\n", labelValue(verb), m.transferBytes[verb])
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP serves the metrics to a Prometheus scraper
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// writeHistogram writes h, labels being a possibly empty list of labels
+// ending with a comma
+func writeHistogram(w io.Writer, name, labels string, h *histogram) {
+	for i, bound := range metricBuckets {
+		var count uint64
+		if h.counts != nil {
+			count = h.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), count)
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+
+	if labels != "" {
+		labels = "{" + labels[:len(labels)-1] + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// labelEscaper escapes label values as the Prometheus text format does
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelValue returns v quoted as a label value
+func labelValue(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countWriter counts the bytes written and keeps the first error
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,176 @@
+package goftp
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Observer is told about the activity of a session, to collect metrics.
+// Durations are measured by the session. Its methods are called from the
+// goroutine using the session.
+type Observer interface {
+	// Dialed is called once the control connection to addr is set up
+	Dialed(addr string, elapsed time.Duration, err error)
+
+	// Reconnected is called after Dialed when the control connection of a
+	// session dialed with DialReconnect is set up
+	Reconnected(addr string)
+
+	// CommandDone is called with the final reply code of each command, and
+	// the time since it was sent. Code is 0 when the command failed without
+	// a reply.
+	CommandDone(verb string, code int, elapsed time.Duration, err error)
+
+	// DataConnected is called once a data connection to addr is set up
+	DataConnected(addr string, elapsed time.Duration, err error)
+
+	// TransferDone is called when the data connection of the verb command,
+	// like RETR, STOR or LIST, is closed, with the bytes it carried
+	TransferDone(verb string, bytes int64, elapsed time.Duration)
+}
+
+// DialObserver reports the activity of the session to o
+func DialObserver(o Observer) DialOption {
+	return func(ftp *FTP) {
+		ftp.observer = o
+	}
+}
+
+// DialReconnect marks the session as replacing one whose connection was
+// lost, for the observer to count reconnections apart from first dials
+func DialReconnect() DialOption {
+	return func(ftp *FTP) {
+		ftp.reconnect = true
+	}
+}
+
+// MultiObserver returns an Observer reporting to all of observers
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) Dialed(addr string, elapsed time.Duration, err error) {
+	for _, o := range m {
+		o.Dialed(addr, elapsed, err)
+	}
+}
+
+func (m multiObserver) Reconnected(addr string) {
+	for _, o := range m {
+		o.Reconnected(addr)
+	}
+}
+
+func (m multiObserver) CommandDone(verb string, code int, elapsed time.Duration, err error) {
+	for _, o := range m {
+		o.CommandDone(verb, code, elapsed, err)
+	}
+}
+
+func (m multiObserver) DataConnected(addr string, elapsed time.Duration, err error) {
+	for _, o := range m {
+		o.DataConnected(addr, elapsed, err)
+	}
+}
+
+func (m multiObserver) TransferDone(verb string, bytes int64, elapsed time.Duration) {
+	for _, o := range m {
+		o.TransferDone(verb, bytes, elapsed)
+	}
+}
+
+// SpanFunc records a finished operation as a span. It bridges to tracing
+// libraries such as OpenTelemetry, with something like:
+//
+//	func(name string, start, end time.Time, attrs map[string]string, err error) {
+//		_, span := tracer.Start(ctx, name, trace.WithTimestamp(start))
+//		for k, v := range attrs {
+//			span.SetAttributes(attribute.String(k, v))
+//		}
+//		if err != nil {
+//			span.RecordError(err)
+//			span.SetStatus(codes.Error, err.Error())
+//		}
+//		span.End(trace.WithTimestamp(end))
+//	}
+type SpanFunc func(name string, start, end time.Time, attrs map[string]string, err error)
+
+// SpanObserver returns an Observer recording every dial, command, data
+// connection and transfer as a span with fn, and reconnections as empty
+// spans
+func SpanObserver(fn SpanFunc) Observer {
+	return spanObserver(fn)
+}
+
+type spanObserver SpanFunc
+
+func (s spanObserver) span(name string, elapsed time.Duration, attrs map[string]string, err error) {
+	end := time.Now()
+	s(name, end.Add(-elapsed), end, attrs, err)
+}
+
+func (s spanObserver) Dialed(addr string, elapsed time.Duration, err error) {
+	s.span("ftp.dial", elapsed, map[string]string{"net.peer.name": addr}, err)
+}
+
+func (s spanObserver) Reconnected(addr string) {
+	s.span("ftp.reconnect", 0, map[string]string{"net.peer.name": addr}, nil)
+}
+
+func (s spanObserver) CommandDone(verb string, code int, elapsed time.Duration, err error) {
+	s.span("FTP "+verb, elapsed, map[string]string{"ftp.command": verb, "ftp.reply_code": strconv.Itoa(code)}, err)
+}
+
+func (s spanObserver) DataConnected(addr string, elapsed time.Duration, err error) {
+	s.span("ftp.data_connect", elapsed, map[string]string{"net.peer.name": addr}, err)
+}
+
+func (s spanObserver) TransferDone(verb string, bytes int64, elapsed time.Duration) {
+	s.span("ftp.transfer", elapsed, map[string]string{"ftp.command": verb, "ftp.bytes": strconv.FormatInt(bytes, 10)}, nil)
+}
+
+// commandVerb returns the upper case verb of command
+func commandVerb(command string) string {
+	if i := strings.IndexByte(command, ' '); i >= 0 {
+		command = command[:i]
+	}
+	return strings.ToUpper(command)
+}
+
+// sentCommand records command as waiting for its final reply
+func (ftp *FTP) sentCommand(command string, err error) {
+	ftp.pending = commandVerb(command)
+	if err != nil {
+		ftp.replied("", err)
+	}
+}
+
+// replied reports a reply, or the failure to read one, to the trace and
+// observer
+func (ftp *FTP) replied(line string, err error) {
+	if err == nil {
+		ftp.trace.replyReceived(line, ftp.sent)
+		ftp.recordReply(line)
+	}
+
+	if ftp.observer == nil || ftp.pending == "" {
+		return
+	}
+
+	code, cerr := strconv.Atoi(line[:min(3, len(line))])
+	switch {
+	case err != nil:
+		code = 0
+	case cerr != nil:
+		code, err = 0, cerr
+	case code < 200:
+		// Preliminary reply, the final one follows
+		return
+	}
+
+	ftp.observer.CommandDone(ftp.pending, code, time.Since(ftp.sent), err)
+	ftp.pending = ""
+}
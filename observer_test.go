@@ -0,0 +1,200 @@
+package goftp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+type recordingObserver struct {
+	events []string
+}
+
+func (r *recordingObserver) Dialed(addr string, elapsed time.Duration, err error) {
+	r.events = append(r.events, fmt.Sprintf("dial %v", err == nil))
+}
+
+func (r *recordingObserver) Reconnected(addr string) {
+	r.events = append(r.events, "reconnect")
+}
+
+func (r *recordingObserver) CommandDone(verb string, code int, elapsed time.Duration, err error) {
+	r.events = append(r.events, fmt.Sprintf("%s %d", verb, code))
+}
+
+func (r *recordingObserver) DataConnected(addr string, elapsed time.Duration, err error) {
+	r.events = append(r.events, "data")
+}
+
+func (r *recordingObserver) TransferDone(verb string, n int64, elapsed time.Duration) {
+	r.events = append(r.events, fmt.Sprintf("transfer %s %d", verb, n))
+}
+
+func TestObserver(t *testing.T) {
+	s := ftptest.NewUnstartedServer()
+	s.DisableMLSD = true
+	s.Start()
+	defer s.Close()
+	s.WriteFile("/file", []byte("data"))
+
+	o := &recordingObserver{}
+	connection, err := Dial(s.Addr, DialObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	o.events = o.events[:0]
+	if _, err = connection.List("/"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed MLSD is followed by LIST on the same data connection
+	got := strings.Join(o.events, "|")
+	if !strings.HasPrefix(got, "TYPE 200|PASV 227|data|MLSD 500|transfer LIST ") || !strings.HasSuffix(got, "|LIST 226") {
+		t.Errorf("List events = %s", got)
+	}
+
+	o.events = o.events[:0]
+	connection.Stor("/missing/file", strings.NewReader("x"))
+	if got := strings.Join(o.events, "|"); got != "TYPE 200|PASV 227|data|STOR 553|transfer STOR 0" {
+		t.Errorf("failed Stor events = %s", got)
+	}
+
+	// Dial failures are reported too
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+	o = &recordingObserver{}
+	if _, err = Dial(addr, DialObserver(o)); err == nil {
+		t.Fatal("dialed a closed port")
+	}
+	if len(o.events) != 1 || o.events[0] != "dial false" {
+		t.Errorf("failed dial events = %q", o.events)
+	}
+}
+
+func TestObserverTransfer(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/file", []byte("data"))
+
+	o := &recordingObserver{}
+	connection, err := Dial(s.Addr, DialObserver(o))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	o.events = o.events[:0]
+	if err = connection.Stor("/up", strings.NewReader("upload")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = connection.Retr("/file", func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "TYPE 200|PASV 227|data|transfer STOR 6|STOR 226|TYPE 200|PASV 227|data|transfer RETR 4|RETR 226"
+	if got := strings.Join(o.events, "|"); got != want {
+		t.Errorf("events = %s\nwant     %s", got, want)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/file", []byte("data"))
+
+	metrics := NewMetrics()
+	var spans []string
+	observer := MultiObserver(metrics, SpanObserver(func(name string, start, end time.Time, attrs map[string]string, err error) {
+		if end.Before(start) {
+			t.Errorf("span %s ends before it starts", name)
+		}
+		spans = append(spans, name)
+	}))
+
+	connection, err := Dial(s.Addr, DialObserver(observer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.Login("anonymous", "anonymous"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = connection.Retr("/file", func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err = metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE ftp_command_duration_seconds histogram\n",
+		"ftp_dials_total 1\n",
+		`ftp_command_duration_seconds_bucket{verb="RETR",le="+Inf"} 1` + "\n",
+		`ftp_command_duration_seconds_count{verb="USER"} 1` + "\n",
+		`ftp_replies_total{code="226"} 1` + "\n",
+		`ftp_replies_total{code="200"} 1` + "\n",
+		"ftp_data_connect_duration_seconds_count 1\n",
+		`ftp_transfer_bytes_total{verb="RETR"} 4` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %q:\n%s", want, out)
+		}
+	}
+
+	want := "ftp.dial|FTP USER|FTP PASS|FTP TYPE|FTP PASV|ftp.data_connect|ftp.transfer|FTP RETR"
+	if got := strings.Join(spans, "|"); got != want {
+		t.Errorf("spans = %s\nwant    %s", got, want)
+	}
+
+	// Reconnections are counted apart, and label values escaped as
+	// Prometheus does, not as Go
+	spans = nil
+	again, err := Dial(s.Addr, DialObserver(observer), DialReconnect())
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Close()
+	metrics.CommandDone("ÉA\"B\\\n\t", 0, 0, io.EOF)
+
+	buf.Reset()
+	if _, err = metrics.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out = buf.String()
+	for _, want := range []string{
+		"ftp_dials_total 2\n",
+		"ftp_reconnects_total 1\n",
+		`ftp_command_errors_total{verb="ÉA\"B\\\n` + "\t\"} 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("metrics lack %q:\n%s", want, out)
+		}
+	}
+	if got := strings.Join(spans, "|"); got != "ftp.dial|ftp.reconnect" {
+		t.Errorf("reconnection spans = %s", got)
+	}
+}
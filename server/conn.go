@@ -0,0 +1,773 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dutchcoders/goftp"
+)
+
+// conn is the server side of a control connection.
+type conn struct {
+	s *Server
+
+	raw    net.Conn
+	ctrl   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	user    string
+	driver  Driver
+	dir     string
+	secure  bool
+	protect bool
+
+	pasv       net.Listener
+	active     string
+	restart    int64
+	renameFrom string
+}
+
+func (s *Server) newConn(rw net.Conn) *conn {
+	_, secure := rw.(*tls.Conn)
+
+	return &conn{
+		s:       s,
+		raw:     rw,
+		ctrl:    rw,
+		reader:  bufio.NewReader(rw),
+		writer:  bufio.NewWriter(rw),
+		dir:     "/",
+		secure:  secure,
+		protect: secure,
+	}
+}
+
+// reply writes a single line reply. The text defaults to goftp.StatusText.
+func (c *conn) reply(code string, text string) {
+	if text == "" {
+		text = goftp.StatusText(code)
+	}
+	fmt.Fprintf(c.writer, "%s %s\r\n", code, text)
+	c.writer.Flush()
+}
+
+func (c *conn) replyf(code string, format string, args ...interface{}) {
+	c.reply(code, fmt.Sprintf(format, args...))
+}
+
+// replyLines writes a multiline reply.
+func (c *conn) replyLines(code string, lines ...string) {
+	for i, line := range lines {
+		switch {
+		case i == len(lines)-1:
+			fmt.Fprintf(c.writer, "%s %s\r\n", code, line)
+		case i == 0:
+			fmt.Fprintf(c.writer, "%s-%s\r\n", code, line)
+		default:
+			fmt.Fprintf(c.writer, " %s\r\n", line)
+		}
+	}
+	c.writer.Flush()
+}
+
+// replyError maps a Driver error to a 550 reply without leaking paths.
+func (c *conn) replyError(err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		c.reply(goftp.StatusFileUnavailable, "No such file or directory.")
+	case errors.Is(err, os.ErrPermission):
+		c.reply(goftp.StatusFileUnavailable, "Permission denied.")
+	case errors.Is(err, os.ErrExist):
+		c.reply(goftp.StatusFileUnavailable, "File exists.")
+	default:
+		c.reply(goftp.StatusFileUnavailable, "")
+	}
+}
+
+type command struct {
+	fn func(c *conn, arg string)
+
+	// open commands are accepted before login.
+	open bool
+}
+
+var commands = map[string]command{
+	"USER": {(*conn).handleUSER, true},
+	"PASS": {(*conn).handlePASS, true},
+	"AUTH": {(*conn).handleAUTH, true},
+	"PBSZ": {(*conn).handlePBSZ, true},
+	"PROT": {(*conn).handlePROT, true},
+	"FEAT": {(*conn).handleFEAT, true},
+	"OPTS": {(*conn).handleOPTS, true},
+	"SYST": {(*conn).handleSYST, true},
+	"NOOP": {(*conn).handleNOOP, true},
+	"TYPE": {(*conn).handleTYPE, false},
+	"MODE": {(*conn).handleMODE, false},
+	"STRU": {(*conn).handleSTRU, false},
+	"PWD":  {(*conn).handlePWD, false},
+	"XPWD": {(*conn).handlePWD, false},
+	"CWD":  {(*conn).handleCWD, false},
+	"XCWD": {(*conn).handleCWD, false},
+	"CDUP": {(*conn).handleCDUP, false},
+	"XCUP": {(*conn).handleCDUP, false},
+	"MKD":  {(*conn).handleMKD, false},
+	"XMKD": {(*conn).handleMKD, false},
+	"RMD":  {(*conn).handleRMD, false},
+	"XRMD": {(*conn).handleRMD, false},
+	"DELE": {(*conn).handleDELE, false},
+	"RNFR": {(*conn).handleRNFR, false},
+	"RNTO": {(*conn).handleRNTO, false},
+	"SIZE": {(*conn).handleSIZE, false},
+	"MDTM": {(*conn).handleMDTM, false},
+	"REST": {(*conn).handleREST, false},
+	"PASV": {(*conn).handlePASV, false},
+	"EPSV": {(*conn).handleEPSV, false},
+	"PORT": {(*conn).handlePORT, false},
+	"EPRT": {(*conn).handleEPRT, false},
+	"LIST": {(*conn).handleLIST, false},
+	"NLST": {(*conn).handleNLST, false},
+	"MLSD": {(*conn).handleMLSD, false},
+	"MLST": {(*conn).handleMLST, false},
+	"STAT": {(*conn).handleSTAT, false},
+	"RETR": {(*conn).handleRETR, false},
+	"STOR": {(*conn).handleSTOR, false},
+	"APPE": {(*conn).handleAPPE, false},
+}
+
+func (c *conn) serve() {
+	defer func() {
+		c.raw.Close()
+		if c.pasv != nil {
+			c.pasv.Close()
+		}
+	}()
+
+	welcome := c.s.Welcome
+	if welcome == "" {
+		welcome = goftp.StatusText(goftp.StatusReady)
+	}
+	c.reply(goftp.StatusReady, welcome)
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+		verb = strings.ToUpper(verb)
+
+		if verb == "QUIT" {
+			c.reply(goftp.StatusConnectionClosing, "Goodbye.")
+			return
+		}
+
+		cmd, ok := commands[verb]
+		switch {
+		case !ok:
+			c.reply(goftp.StatusNotImplemented, "")
+		case !cmd.open && c.driver == nil:
+			c.reply(goftp.StatusNotLoggedIn, "Please login with USER and PASS.")
+		default:
+			cmd.fn(c, arg)
+		}
+	}
+}
+
+// resolve returns the absolute, cleaned path of name relative to the
+// working directory. The result never leaves the user's root.
+func (c *conn) resolve(name string) string {
+	if path.IsAbs(name) {
+		return path.Clean(name)
+	}
+	return path.Join(c.dir, name)
+}
+
+func (c *conn) handleUSER(arg string) {
+	c.user, c.driver = arg, nil
+	c.reply(goftp.StatusUserOK, "")
+}
+
+func (c *conn) handlePASS(arg string) {
+	if c.user == "" {
+		c.reply(goftp.StatusBadSequence, "Login with USER first.")
+		return
+	}
+
+	driver, err := c.s.Auth(c.user, arg)
+	if err != nil || driver == nil {
+		c.reply(goftp.StatusNotLoggedIn, "Login incorrect.")
+		return
+	}
+	c.driver, c.dir = driver, "/"
+	c.reply(goftp.StatusLoggedIn, "")
+}
+
+func (c *conn) handleAUTH(arg string) {
+	switch {
+	case c.s.TLSConfig == nil:
+		c.reply(goftp.StatusNotImplemented, "TLS is not configured.")
+	case strings.ToUpper(arg) != "TLS" && strings.ToUpper(arg) != "SSL":
+		c.reply(goftp.StatusNotImplementedParam, "Unsupported security mechanism.")
+	case c.secure:
+		c.reply(goftp.StatusBadSequence, "Already using TLS.")
+	default:
+		c.reply(goftp.StatusAuthOK, "Proceed with negotiation.")
+
+		c.ctrl = tls.Server(c.ctrl, c.s.TLSConfig)
+		c.reader = bufio.NewReader(c.ctrl)
+		c.writer = bufio.NewWriter(c.ctrl)
+		c.secure = true
+	}
+}
+
+func (c *conn) handlePBSZ(arg string) {
+	c.reply(goftp.StatusOK, "PBSZ=0")
+}
+
+func (c *conn) handlePROT(arg string) {
+	switch {
+	case !c.secure:
+		c.reply(goftp.StatusBadSequence, "PROT needs a secure connection.")
+	case strings.ToUpper(arg) == "P":
+		c.protect = true
+		c.reply(goftp.StatusOK, "Protection level set to P.")
+	case strings.ToUpper(arg) == "C":
+		c.protect = false
+		c.reply(goftp.StatusOK, "Protection level set to C.")
+	default:
+		c.reply(goftp.StatusNotImplementedParam, "Unsupported protection level.")
+	}
+}
+
+func (c *conn) handleFEAT(arg string) {
+	features := []string{"Features:", "SIZE", "MDTM", "REST STREAM", "UTF8", "EPSV",
+		"MLST type*;size*;modify*;perm*;unix.mode*;unique*;"}
+	if c.s.TLSConfig != nil {
+		features = append(features, "AUTH TLS", "PBSZ", "PROT")
+	}
+	c.replyLines(goftp.StatusSystemStatus, append(features, "End")...)
+}
+
+func (c *conn) handleOPTS(arg string) {
+	c.reply(goftp.StatusOK, "")
+}
+
+func (c *conn) handleSYST(arg string) {
+	c.reply(goftp.StatusSystemType, "UNIX Type: L8")
+}
+
+func (c *conn) handleNOOP(arg string) {
+	c.reply(goftp.StatusOK, "")
+}
+
+func (c *conn) handleTYPE(arg string) {
+	switch strings.ToUpper(strings.Fields(arg + " x")[0]) {
+	case "A", "I", "L":
+		c.replyf(goftp.StatusOK, "Type set to %s.", arg)
+	default:
+		c.reply(goftp.StatusNotImplementedParam, "")
+	}
+}
+
+func (c *conn) handleMODE(arg string) {
+	if strings.ToUpper(arg) != "S" {
+		c.reply(goftp.StatusNotImplementedParam, "")
+		return
+	}
+	c.reply(goftp.StatusOK, "")
+}
+
+func (c *conn) handleSTRU(arg string) {
+	if strings.ToUpper(arg) != "F" {
+		c.reply(goftp.StatusNotImplementedParam, "")
+		return
+	}
+	c.reply(goftp.StatusOK, "")
+}
+
+func (c *conn) handlePWD(arg string) {
+	c.replyf(goftp.StatusPathCreated, "\"%s\" is the current directory.", strings.Replace(c.dir, "\"", "\"\"", -1))
+}
+
+func (c *conn) chdir(name string) {
+	fi, err := c.driver.Stat(name)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if !fi.IsDir() {
+		c.reply(goftp.StatusFileUnavailable, "Not a directory.")
+		return
+	}
+	c.dir = name
+	c.reply(goftp.StatusActionOK, "Directory successfully changed.")
+}
+
+func (c *conn) handleCWD(arg string) {
+	c.chdir(c.resolve(arg))
+}
+
+func (c *conn) handleCDUP(arg string) {
+	c.chdir(path.Dir(c.dir))
+}
+
+func (c *conn) handleMKD(arg string) {
+	name := c.resolve(arg)
+	if err := c.driver.Mkdir(name); err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyf(goftp.StatusPathCreated, "\"%s\" created.", strings.Replace(name, "\"", "\"\"", -1))
+}
+
+func (c *conn) remove(name string, dir bool) {
+	fi, err := c.driver.Stat(name)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if fi.IsDir() != dir {
+		c.reply(goftp.StatusFileUnavailable, "Wrong file type.")
+		return
+	}
+	if err = c.driver.Remove(name); err != nil {
+		c.replyError(err)
+		return
+	}
+	c.reply(goftp.StatusActionOK, "")
+}
+
+func (c *conn) handleRMD(arg string) {
+	c.remove(c.resolve(arg), true)
+}
+
+func (c *conn) handleDELE(arg string) {
+	c.remove(c.resolve(arg), false)
+}
+
+func (c *conn) handleRNFR(arg string) {
+	name := c.resolve(arg)
+	if _, err := c.driver.Stat(name); err != nil {
+		c.replyError(err)
+		return
+	}
+	c.renameFrom = name
+	c.reply(goftp.StatusActionPending, "Ready for RNTO.")
+}
+
+func (c *conn) handleRNTO(arg string) {
+	from := c.renameFrom
+	c.renameFrom = ""
+	if from == "" {
+		c.reply(goftp.StatusBadSequence, "RNFR required first.")
+		return
+	}
+	if err := c.driver.Rename(from, c.resolve(arg)); err != nil {
+		c.replyError(err)
+		return
+	}
+	c.reply(goftp.StatusActionOK, "")
+}
+
+func (c *conn) handleSIZE(arg string) {
+	fi, err := c.driver.Stat(c.resolve(arg))
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if fi.IsDir() {
+		c.reply(goftp.StatusFileUnavailable, "Not a regular file.")
+		return
+	}
+	c.replyf(goftp.StatusFileStatus, "%d", fi.Size())
+}
+
+func (c *conn) handleMDTM(arg string) {
+	fi, err := c.driver.Stat(c.resolve(arg))
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.reply(goftp.StatusFileStatus, fi.ModTime().UTC().Format("20060102150405"))
+}
+
+func (c *conn) handleREST(arg string) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		c.reply(goftp.StatusBadArguments, "Bad restart offset.")
+		return
+	}
+	c.restart = offset
+	c.replyf(goftp.StatusActionPending, "Restarting at %d.", offset)
+}
+
+// listen opens the passive listener on the address the client reached us on.
+func (c *conn) listen() (*net.TCPAddr, error) {
+	if c.pasv != nil {
+		c.pasv.Close()
+		c.pasv = nil
+	}
+	c.active = ""
+
+	host, _, err := net.SplitHostPort(c.raw.LocalAddr().String())
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return nil, err
+	}
+	c.pasv = l
+	return l.Addr().(*net.TCPAddr), nil
+}
+
+func (c *conn) handlePASV(arg string) {
+	addr, err := c.listen()
+	if err != nil {
+		c.reply(goftp.StatusCantOpenDataConn, "")
+		return
+	}
+
+	ip := addr.IP.To4()
+	if c.s.PublicIP != "" {
+		ip = net.ParseIP(c.s.PublicIP).To4()
+	}
+	if ip == nil {
+		c.reply(goftp.StatusCantOpenDataConn, "Use EPSV for IPv6.")
+		return
+	}
+
+	c.replyf(goftp.StatusPassiveMode, "Entering Passive Mode (%d,%d,%d,%d,%d,%d).",
+		ip[0], ip[1], ip[2], ip[3], addr.Port>>8, addr.Port&0xff)
+}
+
+func (c *conn) handleEPSV(arg string) {
+	addr, err := c.listen()
+	if err != nil {
+		c.reply(goftp.StatusCantOpenDataConn, "")
+		return
+	}
+	c.replyf(goftp.StatusExtendedPassiveMode, "Entering Extended Passive Mode (|||%d|)", addr.Port)
+}
+
+// setActive records the address of an active data connection. Only the
+// client's own address is accepted, to prevent bounce attacks.
+func (c *conn) setActive(host, port string) {
+	peer, _, _ := net.SplitHostPort(c.raw.RemoteAddr().String())
+	if !net.ParseIP(host).Equal(net.ParseIP(peer)) {
+		c.reply(goftp.StatusBadArguments, "Illegal data connection address.")
+		return
+	}
+
+	if c.pasv != nil {
+		c.pasv.Close()
+		c.pasv = nil
+	}
+	c.active = net.JoinHostPort(host, port)
+	c.reply(goftp.StatusOK, "")
+}
+
+func (c *conn) handlePORT(arg string) {
+	var h [4]int
+	var p1, p2 int
+	if _, err := fmt.Sscanf(arg, "%d,%d,%d,%d,%d,%d", &h[0], &h[1], &h[2], &h[3], &p1, &p2); err != nil {
+		c.reply(goftp.StatusBadArguments, "Illegal PORT command.")
+		return
+	}
+	c.setActive(fmt.Sprintf("%d.%d.%d.%d", h[0], h[1], h[2], h[3]), strconv.Itoa(p1<<8+p2))
+}
+
+func (c *conn) handleEPRT(arg string) {
+	if len(arg) < 2 {
+		c.reply(goftp.StatusBadArguments, "Illegal EPRT command.")
+		return
+	}
+
+	fields := strings.Split(arg, arg[:1])
+	if len(fields) != 5 {
+		c.reply(goftp.StatusBadArguments, "Illegal EPRT command.")
+		return
+	}
+	c.setActive(fields[2], fields[3])
+}
+
+func (c *conn) openData() (net.Conn, error) {
+	var dc net.Conn
+	var err error
+
+	switch {
+	case c.pasv != nil:
+		l := c.pasv
+		c.pasv = nil
+		defer l.Close()
+
+		l.(*net.TCPListener).SetDeadline(time.Now().Add(30 * time.Second))
+		dc, err = l.Accept()
+	case c.active != "":
+		addr := c.active
+		c.active = ""
+
+		dc, err = net.DialTimeout("tcp", addr, 30*time.Second)
+	default:
+		err = errors.New("server: no data connection negotiated")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if c.protect {
+		dc = tls.Server(dc, c.s.TLSConfig)
+	}
+	return dc, nil
+}
+
+// transfer runs fn over a new data connection, framed by the 150 and
+// 226 or 426 replies.
+func (c *conn) transfer(fn func(dc net.Conn) error) {
+	dc, err := c.openData()
+	if err != nil {
+		c.reply(goftp.StatusCantOpenDataConn, "")
+		return
+	}
+
+	c.reply(goftp.StatusFileOK, "")
+
+	err = fn(dc)
+	if cerr := dc.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		c.reply(goftp.StatusTransferAborted, "")
+		return
+	}
+	c.reply(goftp.StatusClosingDataConnection, "")
+}
+
+// listArg strips ls style options some clients pass to LIST.
+func listArg(arg string) string {
+	var fields []string
+	for _, f := range strings.Fields(arg) {
+		if !strings.HasPrefix(f, "-") {
+			fields = append(fields, f)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// readDir lists name, or returns name itself when it is a file.
+func (c *conn) readDir(name string) ([]os.FileInfo, error) {
+	fi, err := c.driver.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []os.FileInfo{fi}, nil
+	}
+	return c.driver.ReadDir(name)
+}
+
+func listLine(fi os.FileInfo) string {
+	t := fi.ModTime()
+	stamp := t.Format("Jan _2  2006")
+	if time.Since(t) < 180*24*time.Hour && time.Until(t) < time.Hour {
+		stamp = t.Format("Jan _2 15:04")
+	}
+
+	mode := fi.Mode().String()
+	if fi.IsDir() {
+		mode = "d" + mode[1:]
+	}
+	return fmt.Sprintf("%s 1 ftp ftp %12d %s %s", mode, fi.Size(), stamp, fi.Name())
+}
+
+func facts(fi os.FileInfo, kind string) string {
+	perm := "adfrw"
+	if fi.IsDir() {
+		perm = "flcdmpe"
+	}
+	if kind == "" {
+		kind = "file"
+		if fi.IsDir() {
+			kind = "dir"
+		}
+	}
+	f := fmt.Sprintf("type=%s;size=%d;modify=%s;perm=%s;unix.mode=0%o;",
+		kind, fi.Size(), fi.ModTime().UTC().Format("20060102150405"), perm, fi.Mode().Perm())
+	// The unique fact lets clients detect links looping back to a directory
+	if unique := uniqueFact(fi); unique != "" {
+		f += "unique=" + unique + ";"
+	}
+	return f
+}
+
+func (c *conn) list(arg string, namesOnly bool) {
+	infos, err := c.readDir(c.resolve(listArg(arg)))
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+
+	c.transfer(func(dc net.Conn) error {
+		w := bufio.NewWriter(dc)
+		for _, fi := range infos {
+			if namesOnly {
+				fmt.Fprintf(w, "%s\r\n", fi.Name())
+			} else {
+				fmt.Fprintf(w, "%s\r\n", listLine(fi))
+			}
+		}
+		return w.Flush()
+	})
+}
+
+func (c *conn) handleLIST(arg string) {
+	c.list(arg, false)
+}
+
+func (c *conn) handleNLST(arg string) {
+	c.list(arg, true)
+}
+
+func (c *conn) handleMLSD(arg string) {
+	name := c.resolve(arg)
+	dir, err := c.driver.Stat(name)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if !dir.IsDir() {
+		c.reply(goftp.StatusFileUnavailable, "Not a directory.")
+		return
+	}
+
+	infos, err := c.driver.ReadDir(name)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+
+	c.transfer(func(dc net.Conn) error {
+		w := bufio.NewWriter(dc)
+		fmt.Fprintf(w, "%s .\r\n", facts(dir, "cdir"))
+		for _, fi := range infos {
+			fmt.Fprintf(w, "%s %s\r\n", facts(fi, ""), fi.Name())
+		}
+		return w.Flush()
+	})
+}
+
+func (c *conn) handleMLST(arg string) {
+	name := c.resolve(arg)
+	fi, err := c.driver.Stat(name)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyLines(goftp.StatusActionOK, "Listing "+name, facts(fi, "")+" "+name, "End")
+}
+
+func (c *conn) handleSTAT(arg string) {
+	if arg == "" {
+		c.replyLines(goftp.StatusSystemStatus, "FTP server status:", "Logged in as "+c.user, "End of status")
+		return
+	}
+
+	name := c.resolve(listArg(arg))
+	infos, err := c.readDir(name)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+
+	lines := []string{"Status of " + name + ":"}
+	for _, fi := range infos {
+		lines = append(lines, listLine(fi))
+	}
+	c.replyLines(goftp.StatusFileStatus, append(lines, "End of status")...)
+}
+
+func (c *conn) handleRETR(arg string) {
+	offset := c.restart
+	c.restart = 0
+
+	r, err := c.driver.Open(c.resolve(arg), offset)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	defer r.Close()
+
+	c.transfer(func(dc net.Conn) error {
+		_, err := io.Copy(dc, r)
+		return err
+	})
+}
+
+func (c *conn) store(name string, offset int64) {
+	w, err := c.driver.Create(name, offset)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+
+	// w is closed before the final reply, so the file is complete when the
+	// client sees 226, and aborted when the data connection failed or
+	// never opened.
+	done := false
+	c.transfer(func(dc net.Conn) error {
+		_, err := io.Copy(w, dc)
+		done = true
+		if err != nil {
+			abort(w)
+			return err
+		}
+		return w.Close()
+	})
+	if !done {
+		abort(w)
+	}
+}
+
+// abort drops the upload to w when it is an Aborter, else closes it.
+func abort(w io.WriteCloser) {
+	if a, ok := w.(Aborter); ok {
+		a.Abort()
+		return
+	}
+	w.Close()
+}
+
+func (c *conn) handleSTOR(arg string) {
+	offset := c.restart
+	c.restart = 0
+
+	c.store(c.resolve(arg), offset)
+}
+
+func (c *conn) handleAPPE(arg string) {
+	c.restart = 0
+
+	name := c.resolve(arg)
+	var offset int64
+	if fi, err := c.driver.Stat(name); err == nil {
+		offset = fi.Size()
+	}
+	c.store(name, offset)
+}
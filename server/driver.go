@@ -0,0 +1,426 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Driver is the file system seen by a logged in user. Names passed to a
+// Driver are slash separated, absolute and cleaned; "/" is the user's root.
+type Driver interface {
+	// Stat returns the FileInfo for name.
+	Stat(name string) (os.FileInfo, error)
+
+	// ReadDir lists the directory name.
+	ReadDir(name string) ([]os.FileInfo, error)
+
+	// Open opens the file name for reading, starting at offset.
+	Open(name string, offset int64) (io.ReadCloser, error)
+
+	// Create opens the file name for writing, creating it if needed. The
+	// first offset bytes of an existing file are kept, the rest is replaced.
+	// A writer implementing Aborter is aborted instead of closed when the
+	// upload fails.
+	Create(name string, offset int64) (io.WriteCloser, error)
+
+	// Mkdir creates the directory name.
+	Mkdir(name string) error
+
+	// Remove removes the file or empty directory name.
+	Remove(name string) error
+
+	// Rename moves from to to.
+	Rename(from, to string) error
+}
+
+// Aborter is implemented by the writers of Driver.Create which can drop a
+// failed upload, leaving the file as it was.
+type Aborter interface {
+	// Abort discards what was written and releases the writer, in place
+	// of Close.
+	Abort() error
+}
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+// LocalDriver serves the directory root of the local file system.
+func LocalDriver(root string) Driver {
+	return localDriver(root)
+}
+
+type localDriver string
+
+func (d localDriver) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(clean(name)))
+}
+
+func (d localDriver) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(d.path(name))
+}
+
+func (d localDriver) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(d.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (d localDriver) Open(name string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(d.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d localDriver) Create(name string, offset int64) (io.WriteCloser, error) {
+	f, err := os.OpenFile(d.path(name), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = f.Truncate(offset); err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d localDriver) Mkdir(name string) error {
+	return os.Mkdir(d.path(name), 0755)
+}
+
+func (d localDriver) Remove(name string) error {
+	return os.Remove(d.path(name))
+}
+
+func (d localDriver) Rename(from, to string) error {
+	return os.Rename(d.path(from), d.path(to))
+}
+
+// MemDriver returns an empty in-memory file system. It is safe for
+// concurrent use by several sessions.
+func MemDriver() Driver {
+	return &memDriver{files: map[string]*memFile{"/": {dir: true, modTime: time.Now()}}}
+}
+
+type memDriver struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	dir     bool
+	data    []byte
+	modTime time.Time
+}
+
+type memInfo struct {
+	name string
+	f    memFile
+}
+
+func (fi *memInfo) Name() string       { return fi.name }
+func (fi *memInfo) Size() int64        { return int64(len(fi.f.data)) }
+func (fi *memInfo) ModTime() time.Time { return fi.f.modTime }
+func (fi *memInfo) IsDir() bool        { return fi.f.dir }
+func (fi *memInfo) Sys() interface{}   { return nil }
+
+func (fi *memInfo) Mode() os.FileMode {
+	if fi.f.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+func (d *memDriver) Stat(name string) (os.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name = clean(name)
+	f := d.files[name]
+	if f == nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return &memInfo{path.Base(name), *f}, nil
+}
+
+func (d *memDriver) ReadDir(name string) ([]os.FileInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name = clean(name)
+	if f := d.files[name]; f == nil || !f.dir {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: os.ErrNotExist}
+	}
+
+	var infos []os.FileInfo
+	for p, f := range d.files {
+		if p != "/" && path.Dir(p) == name {
+			infos = append(infos, &memInfo{path.Base(p), *f})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (d *memDriver) Open(name string, offset int64) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name = clean(name)
+	f := d.files[name]
+	if f == nil || f.dir {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if offset > int64(len(f.data)) {
+		offset = int64(len(f.data))
+	}
+	return io.NopCloser(bytes.NewReader(f.data[offset:])), nil
+}
+
+func (d *memDriver) Create(name string, offset int64) (io.WriteCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name = clean(name)
+	if parent := d.files[path.Dir(name)]; parent == nil || !parent.dir {
+		return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrNotExist}
+	}
+
+	var data []byte
+	if f := d.files[name]; f != nil {
+		if f.dir {
+			return nil, &os.PathError{Op: "create", Path: name, Err: os.ErrExist}
+		}
+		if offset > int64(len(f.data)) {
+			offset = int64(len(f.data))
+		}
+		data = append(data, f.data[:offset]...)
+	}
+
+	w := &memWriter{d: d, name: name}
+	w.buf.Write(data)
+	return w, nil
+}
+
+// memWriter commits its contents on Close, so readers never observe a
+// partially written file, and drops them on Abort.
+type memWriter struct {
+	d    *memDriver
+	name string
+	buf  bytes.Buffer
+}
+
+func (w *memWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *memWriter) Close() error {
+	w.d.mu.Lock()
+	defer w.d.mu.Unlock()
+
+	w.d.files[w.name] = &memFile{data: w.buf.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+func (w *memWriter) Abort() error {
+	w.buf.Reset()
+	return nil
+}
+
+func (d *memDriver) Mkdir(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name = clean(name)
+	if d.files[name] != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrExist}
+	}
+	if parent := d.files[path.Dir(name)]; parent == nil || !parent.dir {
+		return &os.PathError{Op: "mkdir", Path: name, Err: os.ErrNotExist}
+	}
+	d.files[name] = &memFile{dir: true, modTime: time.Now()}
+	return nil
+}
+
+func (d *memDriver) Remove(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name = clean(name)
+	if d.files[name] == nil || name == "/" {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	for p := range d.files {
+		if strings.HasPrefix(p, name+"/") {
+			return &os.PathError{Op: "remove", Path: name, Err: os.ErrExist}
+		}
+	}
+	delete(d.files, name)
+	return nil
+}
+
+func (d *memDriver) Rename(from, to string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	from, to = clean(from), clean(to)
+	if d.files[from] == nil || from == "/" || strings.HasPrefix(to, from+"/") {
+		return &os.PathError{Op: "rename", Path: from, Err: os.ErrNotExist}
+	}
+	if parent := d.files[path.Dir(to)]; parent == nil || !parent.dir {
+		return &os.PathError{Op: "rename", Path: to, Err: os.ErrNotExist}
+	}
+
+	for p, f := range d.files {
+		if p == from || strings.HasPrefix(p, from+"/") {
+			delete(d.files, p)
+			d.files[to+strings.TrimPrefix(p, from)] = f
+		}
+	}
+	return nil
+}
+
+// FSDriver serves fsys read-only. Operations that modify the file system
+// fail with fs.ErrPermission.
+func FSDriver(fsys fs.FS) Driver {
+	return fsDriver{fsys}
+}
+
+type fsDriver struct {
+	fsys fs.FS
+}
+
+func (d fsDriver) path(name string) string {
+	if name = clean(name); name == "/" {
+		return "."
+	}
+	return name[1:]
+}
+
+func (d fsDriver) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(d.fsys, d.path(name))
+}
+
+func (d fsDriver) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(d.fsys, d.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (d fsDriver) Open(name string, offset int64) (io.ReadCloser, error) {
+	f, err := d.fsys.Open(d.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	if seeker, ok := f.(io.Seeker); ok {
+		_, err = seeker.Seek(offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, f, offset)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (d fsDriver) Create(name string, offset int64) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: fs.ErrPermission}
+}
+
+func (d fsDriver) Mkdir(name string) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrPermission}
+}
+
+func (d fsDriver) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (d fsDriver) Rename(from, to string) error {
+	return &fs.PathError{Op: "rename", Path: from, Err: fs.ErrPermission}
+}
+
+// Sub returns a Driver serving the subtree dir of d. It is used to give
+// each user their own root within a shared Driver.
+func Sub(d Driver, dir string) Driver {
+	return subDriver{d, clean(dir)}
+}
+
+type subDriver struct {
+	d   Driver
+	dir string
+}
+
+func (s subDriver) path(name string) string {
+	return path.Join(s.dir, clean(name))
+}
+
+func (s subDriver) Stat(name string) (os.FileInfo, error) {
+	return s.d.Stat(s.path(name))
+}
+
+func (s subDriver) ReadDir(name string) ([]os.FileInfo, error) {
+	return s.d.ReadDir(s.path(name))
+}
+
+func (s subDriver) Open(name string, offset int64) (io.ReadCloser, error) {
+	return s.d.Open(s.path(name), offset)
+}
+
+func (s subDriver) Create(name string, offset int64) (io.WriteCloser, error) {
+	return s.d.Create(s.path(name), offset)
+}
+
+func (s subDriver) Mkdir(name string) error {
+	return s.d.Mkdir(s.path(name))
+}
+
+func (s subDriver) Remove(name string) error {
+	return s.d.Remove(s.path(name))
+}
+
+func (s subDriver) Rename(from, to string) error {
+	return s.d.Rename(s.path(from), s.path(to))
+}
@@ -0,0 +1,151 @@
+// Package server implements an embeddable FTP and FTPS server. Files are
+// served from a pluggable Driver chosen per user by an AuthFunc, and replies
+// use the status codes shared with the goftp client.
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"sync"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close.
+var ErrServerClosed = errors.New("server: Server closed")
+
+// AuthFunc checks the credentials of a user and returns the Driver holding
+// their files. Returning Sub(shared, home) gives every user their own root.
+type AuthFunc func(user, password string) (Driver, error)
+
+// Server is an FTP server.
+type Server struct {
+	// Addr is the TCP address to listen on, ":21" if empty.
+	Addr string
+
+	// Auth authenticates users. It is required.
+	Auth AuthFunc
+
+	// TLSConfig enables AUTH TLS when set.
+	TLSConfig *tls.Config
+
+	// ImplicitTLS makes ListenAndServe expect TLS from the first byte, as
+	// FTPS on port 990 does. It requires TLSConfig.
+	ImplicitTLS bool
+
+	// PublicIP is the IPv4 address announced in PASV replies. The local
+	// address of the control connection is used when empty.
+	PublicIP string
+
+	// Welcome is the text of the 220 greeting.
+	Welcome string
+
+	// ErrorLog logs errors accepting connections. The log package's
+	// standard logger is used when nil.
+	ErrorLog *log.Logger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+	closed   bool
+}
+
+// ListenAndServe listens on s.Addr and serves FTP connections.
+func (s *Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":21"
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if s.ImplicitTLS {
+		if s.TLSConfig == nil {
+			l.Close()
+			return errors.New("server: ImplicitTLS requires TLSConfig")
+		}
+		l = tls.NewListener(l, s.TLSConfig)
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l, serving each in its own goroutine. When
+// s.ImplicitTLS is set, l is expected to be a TLS listener already.
+func (s *Server) Serve(l net.Listener) error {
+	if s.Auth == nil {
+		return errors.New("server: Auth is required")
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listener = l
+	if s.conns == nil {
+		s.conns = map[*conn]struct{}{}
+	}
+	s.mu.Unlock()
+
+	for {
+		rw, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				s.logf("server: accept error: %v", err)
+				continue
+			}
+			return err
+		}
+
+		c := s.newConn(rw)
+
+		s.mu.Lock()
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			c.serve()
+
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops the listener and closes every open connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		// ctrl is replaced by AUTH TLS, raw never changes
+		c.raw.Close()
+	}
+	return err
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
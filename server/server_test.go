@@ -0,0 +1,311 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/dutchcoders/goftp"
+)
+
+func start(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func login(t *testing.T, addr, user, password string) *goftp.FTP {
+	ftp, err := goftp.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ftp.Close() })
+
+	if err = ftp.Login(user, password); err != nil {
+		t.Fatal(err)
+	}
+	return ftp
+}
+
+func retr(t *testing.T, ftp *goftp.FTP, name string) string {
+	var data []byte
+	if _, err := ftp.Retr(name, func(r io.Reader) (err error) {
+		data, err = ioutil.ReadAll(r)
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMemDriver(t *testing.T) {
+	driver := MemDriver()
+	addr := start(t, &Server{Auth: func(user, password string) (Driver, error) {
+		return driver, nil
+	}})
+
+	ftp := login(t, addr, "anonymous", "anonymous")
+	if err := ftp.Mkd("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ftp.Stor("/dir/file.txt", strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if got := retr(t, ftp, "/dir/file.txt"); got != "hello" {
+		t.Errorf("Retr = %q, want %q", got, "hello")
+	}
+	if size, err := ftp.Size("/dir/file.txt"); err != nil || size != 5 {
+		t.Errorf("Size = %d, %v", size, err)
+	}
+
+	// An upload without data connection leaves the file as it was
+	if code, line := ftp.RawCmd("STOR /dir/file.txt"); code != 425 {
+		t.Errorf("STOR without data connection = %s", line)
+	}
+	if got := retr(t, ftp, "/dir/file.txt"); got != "hello" {
+		t.Errorf("Retr after failed STOR = %q, want %q", got, "hello")
+	}
+
+	lines, err := ftp.List("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "type=cdir;") || !strings.HasSuffix(lines[1], " file.txt\r\n") {
+		t.Errorf("List = %q", lines)
+	}
+
+	if err = ftp.Rename("/dir/file.txt", "/dir/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ftp.Dele("/dir/renamed.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = ftp.Rmd("/dir"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserRoots(t *testing.T) {
+	shared := MemDriver()
+	shared.Mkdir("/alice")
+	shared.Mkdir("/bob")
+
+	addr := start(t, &Server{Auth: func(user, password string) (Driver, error) {
+		if password != "secret" {
+			return nil, errors.New("bad password")
+		}
+		return Sub(shared, "/"+user), nil
+	}})
+
+	ftp, err := goftp.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ftp.Login("alice", "wrong"); err == nil || !strings.HasPrefix(err.Error(), goftp.StatusNotLoggedIn) {
+		t.Errorf("Login with wrong password = %v", err)
+	}
+	ftp.Close()
+
+	alice := login(t, addr, "alice", "secret")
+	if err := alice.Stor("/../../note.txt", strings.NewReader("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := shared.Stat("/alice/note.txt"); err != nil {
+		t.Errorf("file not stored under user root: %v", err)
+	}
+
+	bob := login(t, addr, "bob", "secret")
+	if _, err := bob.Size("/note.txt"); err == nil {
+		t.Error("bob can see alice's file")
+	}
+}
+
+func TestFSDriver(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a/b.txt": {Data: []byte("0123456789"), ModTime: time.Now()},
+	}
+	addr := start(t, &Server{Auth: func(user, password string) (Driver, error) {
+		return FSDriver(fsys), nil
+	}})
+
+	ftp := login(t, addr, "anonymous", "")
+	if got := retr(t, ftp, "/a/b.txt"); got != "0123456789" {
+		t.Errorf("Retr = %q", got)
+	}
+	if err := ftp.Stor("/a/c.txt", strings.NewReader("x")); err == nil {
+		t.Error("Stor on read-only driver succeeded")
+	}
+}
+
+func TestLocalDriverUnique(t *testing.T) {
+	dir := t.TempDir()
+	if fi, err := os.Stat(dir); err != nil || uniqueFact(fi) == "" {
+		t.Skip("no inodes on", runtime.GOOS)
+	}
+	for _, name := range []string{"a", "b"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addr := start(t, &Server{Auth: func(user, password string) (Driver, error) {
+		return LocalDriver(dir), nil
+	}})
+
+	ftp := login(t, addr, "anonymous", "")
+	entries, err := ftp.ListEntries("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]string{}
+	for _, e := range entries {
+		fi, err := os.Stat(filepath.Join(dir, e.Name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		unique := e.Facts["unique"]
+		if want := uniqueFact(fi); unique != want {
+			t.Errorf("unique of %s = %q, want %q", e.Name, unique, want)
+		}
+		if other, ok := seen[unique]; ok {
+			t.Errorf("%s and %s share the unique fact %q", other, e.Name, unique)
+		}
+		seen[unique] = e.Name
+	}
+	if len(seen) != 2 {
+		t.Errorf("listed %d entries", len(entries))
+	}
+}
+
+func TestLocalDriverRestart(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := start(t, &Server{Auth: func(user, password string) (Driver, error) {
+		return LocalDriver(dir), nil
+	}})
+
+	ftp := login(t, addr, "anonymous", "")
+	if code, line := ftp.RawCmd("REST 4"); code != 350 {
+		t.Fatalf("REST = %s", line)
+	}
+	if got := retr(t, ftp, "file"); got != "456789" {
+		t.Errorf("Retr after REST = %q", got)
+	}
+}
+
+func selfSigned(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}},
+		&tls.Config{RootCAs: pool, ServerName: "127.0.0.1"}
+}
+
+func TestAuthTLS(t *testing.T) {
+	serverConfig, clientConfig := selfSigned(t)
+
+	driver := MemDriver()
+	addr := start(t, &Server{TLSConfig: serverConfig, Auth: func(user, password string) (Driver, error) {
+		return driver, nil
+	}})
+
+	ftp, err := goftp.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ftp.Close()
+
+	if err = ftp.AuthTLS(clientConfig); err != nil {
+		t.Fatal(err)
+	}
+	if err = ftp.Login("anonymous", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err = ftp.Stor("/secret", strings.NewReader("encrypted")); err != nil {
+		t.Fatal(err)
+	}
+	if got := retr(t, ftp, "/secret"); got != "encrypted" {
+		t.Errorf("Retr = %q", got)
+	}
+}
+
+func TestCloseDuringAuthTLS(t *testing.T) {
+	serverConfig, clientConfig := selfSigned(t)
+
+	s := &Server{TLSConfig: serverConfig, Auth: func(user, password string) (Driver, error) {
+		return MemDriver(), nil
+	}}
+	addr := start(t, s)
+
+	var clients []*goftp.FTP
+	for i := 0; i < 8; i++ {
+		ftp, err := goftp.Connect(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ftp.Close()
+		clients = append(clients, ftp)
+	}
+
+	done := make(chan error, len(clients))
+	for _, ftp := range clients {
+		go func(ftp *goftp.FTP) {
+			done <- ftp.AuthTLS(clientConfig)
+		}(ftp)
+	}
+	time.Sleep(time.Millisecond)
+	s.Close()
+
+	for range clients {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("AuthTLS hung after Close")
+		}
+	}
+}
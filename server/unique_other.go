@@ -0,0 +1,10 @@
+//go:build !unix
+
+package server
+
+import "os"
+
+// uniqueFact returns "", the unique fact being left out
+func uniqueFact(fi os.FileInfo) string {
+	return ""
+}
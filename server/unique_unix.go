@@ -0,0 +1,19 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// uniqueFact returns the device and inode of fi for the unique fact, or ""
+// when its Driver does not provide them
+func uniqueFact(fi os.FileInfo) string {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%xU%x", uint64(st.Dev), uint64(st.Ino))
+}
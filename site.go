@@ -0,0 +1,104 @@
+package goftp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Reply is a reply of the server. Lines holds the text of each line of the
+// reply, without the reply code.
+type Reply struct {
+	Code  int
+	Lines []string
+}
+
+// Message returns the text of the reply, one line per line of the reply
+func (r *Reply) Message() string {
+	return strings.Join(r.Lines, "\n")
+}
+
+// parseReply parses a single or multiline reply, as read by receive
+func parseReply(line string) (*Reply, error) {
+	lines := strings.Split(strings.TrimRight(line, "\r\n"), "\n")
+	if len(lines[0]) < 3 {
+		return nil, errors.New(line)
+	}
+
+	code, err := strconv.Atoi(lines[0][:3])
+	if err != nil {
+		return nil, errors.New(line)
+	}
+
+	reply := &Reply{Code: code}
+	for _, l := range lines {
+		l = strings.TrimRight(l, "\r")
+		if len(l) >= 4 && l[:3] == lines[0][:3] && (l[3] == '-' || l[3] == ' ') {
+			l = l[4:]
+		} else {
+			l = strings.TrimPrefix(l, " ")
+		}
+		reply.Lines = append(reply.Lines, l)
+	}
+	return reply, nil
+}
+
+// Site sends a SITE command, like "CHMOD 644 file" or "HELP", and returns
+// the reply. Replies other than 1xx, 2xx and 3xx are returned along with an
+// error.
+func (ftp *FTP) Site(command string) (*Reply, error) {
+	if err := ftp.send("SITE %s", command); err != nil {
+		return nil, err
+	}
+
+	line, err := ftp.receive()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := parseReply(line)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Code >= 400 {
+		return reply, errors.New(line)
+	}
+	return reply, nil
+}
+
+// Chmod changes the permissions of path with SITE CHMOD. The permission
+// bits of mode are used, along with the setuid, setgid and sticky bits.
+func (ftp *FTP) Chmod(path string, mode os.FileMode) error {
+	_, err := ftp.Site(fmt.Sprintf("CHMOD %04o %s", unixMode(mode), path))
+	return err
+}
+
+// unixMode converts mode to the octal Unix permissions
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= 01000
+	}
+	return m
+}
+
+// isNotImplemented reports whether err is a reply refusing an unknown
+// command or parameter
+func isNotImplemented(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	return strings.HasPrefix(msg, StatusBadCommand) ||
+		strings.HasPrefix(msg, StatusNotImplemented) ||
+		strings.HasPrefix(msg, StatusNotImplementedParam)
+}
@@ -0,0 +1,110 @@
+package goftp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestParseReply(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		want *Reply
+	}{
+		{"200 SITE CHMOD command ok.\r\n", &Reply{200, []string{"SITE CHMOD command ok."}}},
+		{"214-Recognized:\r\n CHMOD\r\n214-HELP\r\n214 Help OK.\r\n", &Reply{214, []string{"Recognized:", "CHMOD", "HELP", "Help OK."}}},
+	} {
+		got, err := parseReply(test.line)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseReply(%q) = %+v, %v, want %+v", test.line, got, err, test.want)
+		}
+	}
+
+	if _, err := parseReply("garbage"); err == nil {
+		t.Error("garbage parsed")
+	}
+}
+
+func TestSite(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/file", []byte("x"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	reply, err := connection.Site("HELP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Code != 214 || reply.Message() != "The following SITE commands are recognized\nCHMOD\nHELP\nHelp OK." {
+		t.Errorf("SITE HELP = %+v", reply)
+	}
+
+	if reply, err = connection.Site("BOGUS"); err == nil || reply.Code != 500 {
+		t.Errorf("SITE BOGUS = %+v, %v", reply, err)
+	}
+
+	if err = connection.Chmod("/file", 0600|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	if cmd := s.Commands(); cmd[len(cmd)-1] != "SITE CHMOD 2600 /file" {
+		t.Errorf("sent %q", cmd[len(cmd)-1])
+	}
+	if e, err := connection.Mlst("/file"); err != nil {
+		t.Error(err)
+	} else if e.Mode != 0600 {
+		t.Errorf("mode after Chmod = %v", e.Mode)
+	}
+
+	if err = connection.Chmod("/missing", 0600); err == nil {
+		t.Error("Chmod of a missing file succeeded")
+	}
+}
+
+func TestUploadPreserveMode(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"run.sh": "#!/bin/sh", "data.txt": "data"}, time.Now())
+	if err := os.Chmod(filepath.Join(local, "run.sh"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(local, "data.txt"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := connection.Upload(local, UploadTo("/remote"), UploadPreserveMode()); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]os.FileMode{"/remote/run.sh": 0750, "/remote/data.txt": 0640} {
+		if e, err := connection.Mlst(name); err != nil {
+			t.Error(err)
+		} else if e.Mode != want {
+			t.Errorf("%s mode = %v, want %v", name, e.Mode, want)
+		}
+	}
+
+	// Servers without SITE CHMOD are asked once
+	s.Reply("SITE", 502, "Command not implemented.")
+	if err := connection.Upload(local, UploadTo("/other"), UploadPreserveMode()); err != nil {
+		t.Fatal(err)
+	}
+	var sites int
+	for _, cmd := range s.Commands() {
+		if len(cmd) > 5 && cmd[:5] == "SITE " {
+			sites++
+		}
+	}
+	if sites != 3 {
+		t.Errorf("%d SITE commands sent, want 3", sites)
+	}
+}
@@ -4,29 +4,77 @@ package goftp
 const (
 	StatusFileOK                = "150"
 	StatusOK                    = "200"
+	StatusSuperfluous           = "202"
 	StatusSystemStatus          = "211"
 	StatusDirectoryStatus       = "212"
 	StatusFileStatus            = "213"
 	StatusConnectionClosing     = "221"
 	StatusSystemType            = "215"
+	StatusReady                 = "220"
 	StatusClosingDataConnection = "226"
+	StatusPassiveMode           = "227"
+	StatusExtendedPassiveMode   = "229"
+	StatusLoggedIn              = "230"
+	StatusLoggedInSecurity      = "232"
+	StatusAuthOK                = "234"
+	StatusSecurityDataOK        = "235"
 	StatusActionOK              = "250"
 	StatusPathCreated           = "257"
+	StatusUserOK                = "331"
+	StatusAccountNeeded         = "332"
+	StatusSecurityDataNeeded    = "334"
+	StatusSecurityDataMore      = "335"
 	StatusActionPending         = "350"
+	StatusNotAvailable          = "421"
+	StatusCantOpenDataConn      = "425"
+	StatusTransferAborted       = "426"
+	StatusFileActionIgnored     = "450"
+	StatusBadCommand            = "500"
+	StatusBadArguments          = "501"
+	StatusNotImplemented        = "502"
+	StatusBadSequence           = "503"
+	StatusNotImplementedParam   = "504"
+	StatusNotLoggedIn           = "530"
+	StatusFileUnavailable       = "550"
+	StatusFileNameNotAllowed    = "553"
 )
 
 var statusText = map[string]string{
 	StatusFileOK:                "File status okay; about to open data connection",
 	StatusOK:                    "Command okay",
+	StatusSuperfluous:           "Command not implemented, superfluous at this site",
 	StatusSystemStatus:          "System status, or system help reply",
 	StatusDirectoryStatus:       "Directory status",
 	StatusFileStatus:            "File status",
 	StatusConnectionClosing:     "Service closing control connection",
 	StatusSystemType:            "System Type",
+	StatusReady:                 "Service ready for new user",
 	StatusClosingDataConnection: "Closing data connection. Requested file action successful.",
+	StatusPassiveMode:           "Entering Passive Mode",
+	StatusExtendedPassiveMode:   "Entering Extended Passive Mode",
+	StatusLoggedIn:              "User logged in, proceed",
+	StatusLoggedInSecurity:      "User logged in, authorized by security data exchange",
+	StatusAuthOK:                "Security data exchange complete",
+	StatusSecurityDataOK:        "Security data exchange completed successfully",
 	StatusActionOK:              "Requested file action okay, completed",
 	StatusPathCreated:           "Pathname Created",
+	StatusUserOK:                "User name okay, need password",
+	StatusAccountNeeded:         "Need account for login",
+	StatusSecurityDataNeeded:    "Security mechanism accepted, security data required",
+	StatusSecurityDataMore:      "Security data accepted, more required",
 	StatusActionPending:         "Requested file action pending further information",
+	StatusNotAvailable:          "Service not available, closing control connection",
+	StatusCantOpenDataConn:      "Can't open data connection",
+	StatusTransferAborted:       "Connection closed; transfer aborted",
+	StatusFileActionIgnored:     "Requested file action not taken",
+	StatusBadCommand:            "Syntax error, command unrecognized",
+	StatusBadArguments:          "Syntax error in parameters or arguments",
+	StatusNotImplemented:        "Command not implemented",
+	StatusBadSequence:           "Bad sequence of commands",
+	StatusNotImplementedParam:   "Command not implemented for that parameter",
+	StatusNotLoggedIn:           "Not logged in",
+	StatusFileUnavailable:       "Requested action not taken. File unavailable",
+	StatusFileNameNotAllowed:    "Requested action not taken. File name not allowed",
 }
 
 // StatusText returns a text for the FTP status code. It returns the empty
@@ -0,0 +1,423 @@
+package goftp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Direction is the way changes flow in Sync
+type Direction int
+
+// Sync directions
+const (
+	// Push makes the remote tree match the local one
+	Push Direction = iota
+	// Pull makes the local tree match the remote one
+	Pull
+	// TwoWay copies each changed file to the side holding the older copy
+	TwoWay
+)
+
+// Compare selects how Sync decides that a file changed
+type Compare int
+
+// Comparison methods
+const (
+	// CompareModTime compares sizes and modification times
+	CompareModTime Compare = iota
+	// CompareSize compares sizes only
+	CompareSize
+	// CompareChecksum compares checksums of the contents. The server
+	// computes the remote sum when it supports a hash command, otherwise
+	// the remote file is read.
+	CompareChecksum
+)
+
+// SyncOp is an operation planned by Sync
+type SyncOp int
+
+// Sync operations
+const (
+	SyncUpload SyncOp = iota
+	SyncDownload
+	SyncMkdirRemote
+	SyncMkdirLocal
+	SyncDeleteRemote
+	SyncDeleteLocal
+	// SyncConflict marks a path that is a file on one side and a directory
+	// on the other. It is reported but never acted upon.
+	SyncConflict
+)
+
+var syncOpNames = map[SyncOp]string{
+	SyncUpload:       "upload",
+	SyncDownload:     "download",
+	SyncMkdirRemote:  "mkdir remote",
+	SyncMkdirLocal:   "mkdir local",
+	SyncDeleteRemote: "delete remote",
+	SyncDeleteLocal:  "delete local",
+	SyncConflict:     "conflict",
+}
+
+func (op SyncOp) String() string {
+	return syncOpNames[op]
+}
+
+// SyncAction is a step of a synchronization plan. Path is relative to the
+// synchronized roots and slash separated.
+type SyncAction struct {
+	Op     SyncOp
+	Path   string
+	Reason string
+}
+
+func (a SyncAction) String() string {
+	if a.Reason == "" {
+		return fmt.Sprintf("%s %s", a.Op, a.Path)
+	}
+	return fmt.Sprintf("%s %s (%s)", a.Op, a.Path, a.Reason)
+}
+
+// SyncOption configures Sync
+type SyncOption func(*syncer)
+
+// SyncDirection sets the direction of the synchronization, Push by default
+func SyncDirection(direction Direction) SyncOption {
+	return func(s *syncer) {
+		s.direction = direction
+	}
+}
+
+// SyncCompare sets how changed files are detected, CompareModTime by default
+func SyncCompare(compare Compare) SyncOption {
+	return func(s *syncer) {
+		s.compare = compare
+	}
+}
+
+// SyncDelete removes files and directories missing from the source side.
+// It has no effect on TwoWay synchronizations.
+func SyncDelete() SyncOption {
+	return func(s *syncer) {
+		s.delete = true
+	}
+}
+
+// SyncDryRun prints the plan to w, one action per line, without changing
+// anything
+func SyncDryRun(w io.Writer) SyncOption {
+	return func(s *syncer) {
+		s.dryRun = w
+	}
+}
+
+type syncer struct {
+	ftp       *FTP
+	direction Direction
+	compare   Compare
+	delete    bool
+	dryRun    io.Writer
+
+	localRoot  string
+	remoteRoot string
+}
+
+// syncFile is the state of a path on one side
+type syncFile struct {
+	dir     bool
+	size    int64
+	modTime time.Time
+	entry   *Entry
+}
+
+// Sync synchronizes the local directory localPath with the remote directory
+// remotePath, transferring only files that changed. It returns the plan it
+// carried out, or would carry out for a dry run.
+func (ftp *FTP) Sync(localPath, remotePath string, opts ...SyncOption) ([]SyncAction, error) {
+	s := &syncer{ftp: ftp, localRoot: localPath, remoteRoot: remotePath}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	local, err := s.scanLocal()
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := s.scanRemote()
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := s.plan(local, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.dryRun != nil {
+		for _, action := range plan {
+			fmt.Fprintln(s.dryRun, action)
+		}
+		return plan, nil
+	}
+
+	return plan, s.apply(plan, local, remote)
+}
+
+func (s *syncer) scanLocal() (map[string]*syncFile, error) {
+	files := map[string]*syncFile{}
+	if _, err := os.Stat(s.localRoot); os.IsNotExist(err) && s.direction != Push {
+		return files, nil
+	}
+
+	err := filepath.WalkDir(s.localRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == s.localRoot {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.localRoot, p)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			// Ignore other special files
+			return nil
+		}
+
+		files[filepath.ToSlash(rel)] = &syncFile{dir: info.IsDir(), size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return files, err
+}
+
+func (s *syncer) scanRemote() (map[string]*syncFile, error) {
+	files := map[string]*syncFile{}
+
+	err := s.ftp.WalkDir(s.remoteRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == s.remoteRoot && isNotExist(err) && s.direction != Pull {
+				// The remote root is created by the push
+				return fs.SkipAll
+			}
+			return err
+		}
+
+		rel := relPath(s.remoteRoot, p)
+		if rel == "" {
+			return nil
+		}
+
+		e := d.(entryInfo).e
+		if e.Type == EntryTypeLink {
+			return nil
+		}
+
+		files[rel] = &syncFile{dir: e.IsDir(), size: e.Size, modTime: e.ModTime, entry: e}
+		return nil
+	})
+	return files, err
+}
+
+// changed reports whether the file differs on both sides, and why
+func (s *syncer) changed(rel string, local, remote *syncFile) (bool, string, error) {
+	if local.size != remote.size {
+		return true, "size differs", nil
+	}
+
+	switch s.compare {
+	case CompareModTime:
+		l, r := local.modTime.Truncate(time.Second), remote.modTime.Truncate(time.Second)
+		switch {
+		case s.direction == Push && l.After(r):
+			return true, "local is newer", nil
+		case s.direction == Pull && r.After(l):
+			return true, "remote is newer", nil
+		case s.direction == TwoWay && !l.Equal(r):
+			return true, "modification time differs", nil
+		}
+	case CompareChecksum:
+		same, err := s.sameChecksum(rel)
+		if err != nil || same {
+			return false, "", err
+		}
+		return true, "checksum differs", nil
+	}
+
+	return false, "", nil
+}
+
+// remoteModTime replaces minute precision LIST times with MDTM
+func (s *syncer) remoteModTime(rel string, remote *syncFile) {
+	if remote.entry.Facts["modify"] != "" {
+		return
+	}
+
+	if t, err := s.ftp.ModTime(path.Join(s.remoteRoot, rel)); err == nil {
+		remote.modTime = t
+	}
+}
+
+// sameChecksum compares the checksums of rel, computed by the server when
+// it can, or else by reading the remote file
+func (s *syncer) sameChecksum(rel string) (bool, error) {
+	remotePath := path.Join(s.remoteRoot, rel)
+
+	algorithm, err := s.ftp.verifyAlgorithm()
+	serverSide := err == nil
+	if !serverSide {
+		algorithm = HashSHA256
+	}
+
+	localSum, err := hashFile(filepath.Join(s.localRoot, filepath.FromSlash(rel)), algorithm)
+	if err != nil {
+		return false, err
+	}
+
+	var remoteSum string
+	if serverSide {
+		if remoteSum, err = s.ftp.Hash(remotePath, algorithm); err != nil {
+			return false, err
+		}
+	} else {
+		h := newHash(algorithm)
+		if _, err = s.ftp.Retr(remotePath, func(r io.Reader) error {
+			_, err := io.Copy(h, r)
+			return err
+		}); err != nil {
+			return false, err
+		}
+		remoteSum = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return sameSum(localSum, remoteSum), nil
+}
+
+func (s *syncer) plan(local, remote map[string]*syncFile) ([]SyncAction, error) {
+	var names []string
+	for name := range local {
+		names = append(names, name)
+	}
+	for name := range remote {
+		if local[name] == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var plan, deletes []SyncAction
+	for _, name := range names {
+		l, r := local[name], remote[name]
+
+		switch {
+		case l != nil && r != nil && l.dir != r.dir:
+			plan = append(plan, SyncAction{SyncConflict, name, "file and directory"})
+		case l != nil && r != nil:
+			if l.dir {
+				continue
+			}
+			if s.compare == CompareModTime || s.direction == TwoWay {
+				s.remoteModTime(name, r)
+			}
+
+			changed, reason, err := s.changed(name, l, r)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				continue
+			}
+
+			op := SyncUpload
+			if s.direction == Pull || (s.direction == TwoWay && r.modTime.After(l.modTime)) {
+				op = SyncDownload
+			}
+			plan = append(plan, SyncAction{op, name, reason})
+		case l != nil:
+			switch {
+			case s.direction != Pull && l.dir:
+				plan = append(plan, SyncAction{SyncMkdirRemote, name, "missing"})
+			case s.direction != Pull:
+				plan = append(plan, SyncAction{SyncUpload, name, "missing"})
+			case s.delete:
+				deletes = append(deletes, SyncAction{SyncDeleteLocal, name, "extraneous"})
+			}
+		default:
+			switch {
+			case s.direction != Push && r.dir:
+				plan = append(plan, SyncAction{SyncMkdirLocal, name, "missing"})
+			case s.direction != Push:
+				plan = append(plan, SyncAction{SyncDownload, name, "missing"})
+			case s.delete:
+				deletes = append(deletes, SyncAction{SyncDeleteRemote, name, "extraneous"})
+			}
+		}
+	}
+
+	// Contents are deleted before their directories
+	for i := len(deletes) - 1; i >= 0; i-- {
+		plan = append(plan, deletes[i])
+	}
+	return plan, nil
+}
+
+func (s *syncer) apply(plan []SyncAction, local, remote map[string]*syncFile) error {
+	if s.direction != Pull {
+		if err := s.ftp.MkdirAll(s.remoteRoot); err != nil {
+			return err
+		}
+	}
+	if s.direction != Push {
+		if err := os.MkdirAll(s.localRoot, 0755); err != nil {
+			return err
+		}
+	}
+
+	d := &downloader{ftp: s.ftp}
+	for _, action := range plan {
+		localPath := filepath.Join(s.localRoot, filepath.FromSlash(action.Path))
+		remotePath := path.Join(s.remoteRoot, action.Path)
+
+		var err error
+		switch action.Op {
+		case SyncUpload:
+			if err = s.ftp.copyFile(localPath, remotePath); err == nil {
+				// Keep the time, or the next TwoWay run copies the file back.
+				// Not all servers can set it.
+				s.ftp.SetModTime(remotePath, local[action.Path].modTime)
+			}
+		case SyncDownload:
+			err = d.file(remotePath, localPath, remote[action.Path].entry)
+		case SyncMkdirRemote:
+			err = s.ftp.Mkd(remotePath)
+		case SyncMkdirLocal:
+			err = os.Mkdir(localPath, 0755)
+		case SyncDeleteRemote:
+			if remote[action.Path].dir {
+				err = s.ftp.Rmd(remotePath)
+			} else {
+				err = s.ftp.Dele(remotePath)
+			}
+		case SyncDeleteLocal:
+			err = os.Remove(localPath)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
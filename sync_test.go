@@ -0,0 +1,179 @@
+package goftp
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func writeLocal(t *testing.T, root string, files map[string]string, mtime time.Time) {
+	for name, data := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncPush(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"a.txt": "a", "dir/b.txt": "b"}, time.Now().Add(-time.Hour))
+
+	plan, err := connection.Sync(local, "/remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 3 {
+		t.Errorf("first sync plan = %v", plan)
+	}
+	if got, _ := s.ReadFile("/remote/dir/b.txt"); string(got) != "b" {
+		t.Errorf("dir/b.txt = %q", got)
+	}
+
+	// Nothing changed, nothing to do
+	if plan, err = connection.Sync(local, "/remote"); err != nil || len(plan) != 0 {
+		t.Errorf("second sync plan = %v, %v", plan, err)
+	}
+
+	writeLocal(t, local, map[string]string{"a.txt": "changed"}, time.Now())
+	s.WriteFile("/remote/extra.txt", []byte("extra"))
+
+	var out bytes.Buffer
+	if plan, err = connection.Sync(local, "/remote", SyncDelete(), SyncDryRun(&out)); err != nil {
+		t.Fatal(err)
+	}
+	want := []SyncAction{
+		{SyncUpload, "a.txt", "size differs"},
+		{SyncDeleteRemote, "extra.txt", "extraneous"},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("dry run plan = %v, want %v", plan, want)
+	}
+	if out.String() != "upload a.txt (size differs)\ndelete remote extra.txt (extraneous)\n" {
+		t.Errorf("dry run output = %q", out.String())
+	}
+	if got, _ := s.ReadFile("/remote/a.txt"); string(got) != "a" {
+		t.Errorf("dry run changed a.txt to %q", got)
+	}
+
+	if _, err = connection.Sync(local, "/remote", SyncDelete()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.ReadFile("/remote/a.txt"); string(got) != "changed" {
+		t.Errorf("a.txt = %q after sync", got)
+	}
+	if exists, _ := s.Stat("/remote/extra.txt"); exists {
+		t.Error("extraneous file not deleted")
+	}
+}
+
+func TestSyncCurrentDir(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.Mkdir("/remote")
+
+	connection := connect(t, s)
+	defer connection.Close()
+	if err := connection.Cwd("/remote"); err != nil {
+		t.Fatal(err)
+	}
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{".hidden": "h", "hidden": "p", ".cfg/x": "x"}, time.Now().Add(-time.Hour))
+
+	if _, err := connection.Sync(local, ".", SyncDelete()); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := connection.Sync(local, ".", SyncDelete()); err != nil || len(plan) != 0 {
+		t.Errorf("second sync plan = %v, %v", plan, err)
+	}
+	for name, want := range map[string]string{"/remote/.hidden": "h", "/remote/hidden": "p", "/remote/.cfg/x": "x"} {
+		if got, _ := s.ReadFile(name); string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSyncPull(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/remote/a.txt", []byte("remote"))
+	s.WriteFile("/remote/dir/b.txt", []byte("b"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"stale.txt": "stale"}, time.Now())
+
+	if _, err := connection.Sync(local, "/remote", SyncDirection(Pull), SyncDelete()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(local, "dir", "b.txt")); string(got) != "b" {
+		t.Errorf("dir/b.txt = %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(local, "stale.txt")); !os.IsNotExist(err) {
+		t.Errorf("stale.txt not deleted: %v", err)
+	}
+
+	if plan, err := connection.Sync(local, "/remote", SyncDirection(Pull)); err != nil || len(plan) != 0 {
+		t.Errorf("second pull plan = %v, %v", plan, err)
+	}
+}
+
+func TestSyncTwoWayChecksum(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	old := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	s.WriteFile("/remote/same.txt", []byte("1234"))
+	s.WriteFile("/remote/theirs.txt", []byte("new!"))
+	s.Chtimes("/remote/same.txt", old)
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"same.txt": "1234", "theirs.txt": "old!"}, old)
+	writeLocal(t, local, map[string]string{"mine.txt": "mine"}, time.Now())
+
+	plan, err := connection.Sync(local, "/remote", SyncDirection(TwoWay), SyncCompare(CompareChecksum))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, action := range plan {
+		got = append(got, action.String())
+	}
+	want := "upload mine.txt (missing)|download theirs.txt (checksum differs)"
+	if strings.Join(got, "|") != want {
+		t.Errorf("plan = %q, want %q", got, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(local, "theirs.txt")); string(data) != "new!" {
+		t.Errorf("theirs.txt = %q", data)
+	}
+
+	// Both sides now hold the same times
+	if plan, err = connection.Sync(local, "/remote", SyncDirection(TwoWay)); err != nil || len(plan) != 0 {
+		t.Errorf("second two-way plan = %v, %v", plan, err)
+	}
+}
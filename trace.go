@@ -0,0 +1,155 @@
+package goftp
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trace holds hooks called during a session, in the spirit of
+// net/http/httptrace. Any hook may be nil.
+type Trace struct {
+	// CommandSent is called with each command sent, without the line
+	// ending. Passwords and security data are redacted.
+	CommandSent func(command string)
+
+	// ReplyReceived is called with each complete reply, and the time since
+	// the command it answers was sent, or since dialing for the greeting
+	ReplyReceived func(reply string, elapsed time.Duration)
+
+	// DataOpened is called once a data connection is established
+	DataOpened func(addr string)
+
+	// DataClosed is called when a data connection is closed, with the time
+	// it was open
+	DataClosed func(addr string, elapsed time.Duration)
+}
+
+// SlogTrace returns a Trace logging the session to logger, at debug level
+func SlogTrace(logger *slog.Logger) *Trace {
+	ctx := context.Background()
+	return &Trace{
+		CommandSent: func(command string) {
+			logger.LogAttrs(ctx, slog.LevelDebug, "ftp command", slog.String("command", command))
+		},
+		ReplyReceived: func(reply string, elapsed time.Duration) {
+			logger.LogAttrs(ctx, slog.LevelDebug, "ftp reply",
+				slog.String("reply", strings.TrimRight(reply, "\r\n")), slog.Duration("elapsed", elapsed))
+		},
+		DataOpened: func(addr string) {
+			logger.LogAttrs(ctx, slog.LevelDebug, "ftp data connection opened", slog.String("addr", addr))
+		},
+		DataClosed: func(addr string, elapsed time.Duration) {
+			logger.LogAttrs(ctx, slog.LevelDebug, "ftp data connection closed",
+				slog.String("addr", addr), slog.Duration("elapsed", elapsed))
+		},
+	}
+}
+
+// debugTrace is the trace of ConnectDbg, printed with the standard logger
+func debugTrace() *Trace {
+	return &Trace{
+		CommandSent:   func(command string) { log.Printf("> %s", command) },
+		ReplyReceived: func(reply string, elapsed time.Duration) { log.Printf("< %s", reply) },
+		DataOpened:    func(addr string) { log.Printf("Connected to %s\n", addr) },
+	}
+}
+
+func (t *Trace) commandSent(command string) {
+	if t != nil && t.CommandSent != nil {
+		t.CommandSent(redact(command))
+	}
+}
+
+func (t *Trace) replyReceived(reply string, sent time.Time) {
+	if t != nil && t.ReplyReceived != nil {
+		t.ReplyReceived(reply, time.Since(sent))
+	}
+}
+
+// dataOpened reports the data connection conn and returns it wrapped to
+// report its closing, and record its bytes
+func (ftp *FTP) dataOpened(conn net.Conn) net.Conn {
+	record := ftp.record != nil && ftp.recordData
+	if ftp.trace == nil && ftp.observer == nil && !record {
+		return conn
+	}
+	if record {
+		ftp.recordLine("D", "open")
+	}
+
+	addr := conn.RemoteAddr().String()
+	if ftp.trace != nil && ftp.trace.DataOpened != nil {
+		ftp.trace.DataOpened(addr)
+	}
+	return &dataConn{Conn: conn, ftp: ftp, addr: addr, verb: ftp.pending, opened: time.Now(), record: record}
+}
+
+// dataConn counts the bytes of a data connection, and reports its first
+// Close
+type dataConn struct {
+	net.Conn
+	ftp    *FTP
+	addr   string
+	verb   string
+	opened time.Time
+	bytes  int64
+	record bool
+	once   sync.Once
+}
+
+func (c *dataConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.bytes += int64(n)
+	if c.record && n > 0 {
+		c.ftp.recordLine("D<", strconv.Quote(string(p[:n])))
+	}
+	return n, err
+}
+
+func (c *dataConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.bytes += int64(n)
+	if c.record && n > 0 {
+		c.ftp.recordLine("D>", strconv.Quote(string(p[:n])))
+	}
+	return n, err
+}
+
+func (c *dataConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		if c.record {
+			c.ftp.recordLine("D", "close")
+		}
+		elapsed := time.Since(c.opened)
+		if t := c.ftp.trace; t != nil && t.DataClosed != nil {
+			t.DataClosed(c.addr, elapsed)
+		}
+		if o := c.ftp.observer; o != nil {
+			// The command may have been replaced, like MLSD by LIST
+			verb := c.ftp.pending
+			if verb == "" {
+				verb = c.verb
+			}
+			o.TransferDone(verb, c.bytes, elapsed)
+		}
+	})
+	return err
+}
+
+// redact hides the argument of commands carrying secrets: passwords, and
+// the security data and protected commands of RFC 2228
+func redact(command string) string {
+	for _, verb := range []string{"PASS ", "ACCT ", "ADAT ", "MIC ", "CONF ", "ENC "} {
+		if len(command) > len(verb) && strings.EqualFold(command[:len(verb)], verb) {
+			return command[:len(verb)] + "****"
+		}
+	}
+	return command
+}
@@ -0,0 +1,102 @@
+package goftp
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestTrace(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/file", []byte("data"))
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	}
+
+	connection, err := Dial(s.Addr, DialTrace(&Trace{
+		CommandSent: func(command string) { record("> " + command) },
+		ReplyReceived: func(reply string, elapsed time.Duration) {
+			if elapsed < 0 {
+				t.Errorf("negative elapsed time for %q", reply)
+			}
+			record("< " + reply[:3])
+		},
+		DataOpened: func(addr string) { record("open") },
+		DataClosed: func(addr string, elapsed time.Duration) { record("close") },
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.Login("user", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = connection.Retr("/file", func(r io.Reader) error {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "< 220|> USER user|< 331|> PASS ****|< 230|> TYPE I|< 200|> PASV|< 227|> RETR /file|open|< 150|close|< 226"
+	mu.Lock()
+	got := strings.Join(events, "|")
+	mu.Unlock()
+	if got != want {
+		t.Errorf("trace = %s\nwant    %s", got, want)
+	}
+}
+
+func TestDialLogger(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	connection, err := Dial(s.Addr, DialLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if err = connection.Login("user", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{`msg="ftp command" command="USER user"`, `command="PASS ****"`, `msg="ftp reply" reply="230 `, "elapsed="} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("password logged:\n%s", out)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	for command, want := range map[string]string{
+		"PASS hunter2": "PASS ****",
+		"pass hunter2": "pass ****",
+		"ACCT billing": "ACCT ****",
+		"USER anon":    "USER anon",
+		"PASSIVE":      "PASSIVE",
+	} {
+		if got := redact(command); got != want {
+			t.Errorf("redact(%q) = %q, want %q", command, got, want)
+		}
+	}
+}
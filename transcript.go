@@ -0,0 +1,231 @@
+package goftp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A transcript has one entry per line:
+//
+//	# comment
+//	> command sent, with passwords and security data redacted
+//	< reply line received
+//	D open
+//	D< "quoted bytes received on the data connection"
+//	D> "quoted bytes sent on the data connection"
+//	D close
+//
+// Data connection entries are only recorded with DialRecordData.
+
+// DialRecord writes a transcript of the control connection to w, to be
+// played back with Replay
+func DialRecord(w io.Writer) DialOption {
+	return func(ftp *FTP) {
+		ftp.record = w
+	}
+}
+
+// DialRecordData adds the bytes of the data connections to the transcript
+// of DialRecord
+func DialRecordData() DialOption {
+	return func(ftp *FTP) {
+		ftp.recordData = true
+	}
+}
+
+// recordLine appends an entry to the transcript, if recording
+func (ftp *FTP) recordLine(prefix, text string) {
+	if ftp.record == nil {
+		return
+	}
+	ftp.recordMu.Lock()
+	defer ftp.recordMu.Unlock()
+	fmt.Fprintf(ftp.record, "%s %s\n", prefix, text)
+}
+
+// recordReply records each line of reply
+func (ftp *FTP) recordReply(reply string) {
+	for _, line := range strings.SplitAfter(reply, "\n") {
+		if line != "" {
+			ftp.recordLine("<", strings.TrimRight(line, "\r\n"))
+		}
+	}
+}
+
+// Replay returns a session whose server is played by the transcript
+// recorded with DialRecord, replying to each command as the server did.
+// The session fails with a 421 reply on a command other than the one
+// recorded. TLS is not negotiated during a replay.
+func Replay(transcript io.Reader, opts ...DialOption) (*FTP, error) {
+	r, err := parseTranscript(transcript)
+	if err != nil {
+		return nil, err
+	}
+
+	object := &FTP{addr: "replay", sent: time.Now(), replay: r}
+	for _, opt := range opts {
+		opt(object)
+	}
+
+	client, server := net.Pipe()
+	go r.serve(server)
+	if err = object.start(client); err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// replayer plays the server side of a transcript
+type replayer struct {
+	control []replayEntry
+
+	data chan replayData
+}
+
+// replayEntry is a command to expect, or a reply to send
+type replayEntry struct {
+	command string
+	reply   string
+}
+
+// replayData is a data connection, with the bytes to send and whether the
+// client sent any
+type replayData struct {
+	received []byte
+	sent     bool
+}
+
+func parseTranscript(transcript io.Reader) (*replayer, error) {
+	r := &replayer{}
+	var data []replayData
+	var open *replayData
+	var reply []string
+
+	// Replies are sent whole, grouping multiline replies like readReply
+	flush := func() {
+		if len(reply) > 0 {
+			r.control = append(r.control, replayEntry{reply: strings.Join(reply, "\r\n") + "\r\n"})
+			reply = nil
+		}
+	}
+
+	scanner := bufio.NewScanner(transcript)
+	scanner.Buffer(nil, 1<<24)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		prefix, text, _ := strings.Cut(line, " ")
+
+		switch prefix {
+		case "", "#":
+		case ">":
+			flush()
+			r.control = append(r.control, replayEntry{command: text})
+		case "<":
+			reply = append(reply, text)
+			first := reply[0]
+			multiline := len(first) >= 4 && first[3] == '-'
+			if !multiline || len(reply) > 1 && (len(text) < 4 || strings.HasPrefix(text, first[:3]+" ")) {
+				flush()
+			}
+		case "D":
+			switch text {
+			case "open":
+				data = append(data, replayData{})
+				open = &data[len(data)-1]
+			case "close":
+				open = nil
+			default:
+				return nil, fmt.Errorf("transcript line %d: unknown data entry %q", n, line)
+			}
+		case "D<", "D>":
+			if open == nil {
+				return nil, fmt.Errorf("transcript line %d: data outside a data connection", n)
+			}
+			b, err := strconv.Unquote(text)
+			if err != nil {
+				return nil, fmt.Errorf("transcript line %d: %v", n, err)
+			}
+			if prefix == "D<" {
+				open.received = append(open.received, b...)
+			} else {
+				open.sent = true
+			}
+		default:
+			return nil, fmt.Errorf("transcript line %d: unknown entry %q", n, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+
+	if len(r.control) == 0 || r.control[0].reply == "" {
+		return nil, errors.New("transcript does not start with a greeting")
+	}
+
+	r.data = make(chan replayData, len(data))
+	for _, d := range data {
+		r.data <- d
+	}
+	return r, nil
+}
+
+// serve answers the client on conn until the transcript ends
+func (r *replayer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for _, entry := range r.control {
+		if entry.reply != "" {
+			if _, err := io.WriteString(conn, entry.reply); err != nil {
+				return
+			}
+			continue
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := redact(strings.TrimRight(line, "\r\n"))
+		if !strings.EqualFold(command, entry.command) {
+			fmt.Fprintf(conn, "421 Replay expected %q, got %q\r\n", entry.command, command)
+			return
+		}
+	}
+
+	if _, err := reader.ReadString('\n'); err == nil {
+		io.WriteString(conn, "421 End of replayed transcript\r\n")
+	}
+}
+
+// dial opens the next recorded data connection, or an empty one when the
+// data was not recorded
+func (r *replayer) dial() (net.Conn, error) {
+	var d replayData
+	select {
+	case d = <-r.data:
+	default:
+	}
+
+	client, server := net.Pipe()
+	go func() {
+		done := make(chan struct{})
+		go func() {
+			io.Copy(io.Discard, server)
+			close(done)
+		}()
+		server.Write(d.received)
+		if d.sent {
+			<-done
+		}
+		server.Close()
+	}()
+	return client, nil
+}
@@ -0,0 +1,149 @@
+package goftp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func session(connection *FTP) (list, stat []string, file string, err error) {
+	if err = connection.Login("anonymous", "secret"); err != nil {
+		return
+	}
+	if list, err = connection.List("/dir"); err != nil {
+		return
+	}
+	if stat, err = connection.Stat("/dir/a.txt"); err != nil {
+		return
+	}
+	if err = connection.Stor("/dir/b.txt", strings.NewReader("uploaded")); err != nil {
+		return
+	}
+	_, err = connection.Retr("/dir/a.txt", func(r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		file = string(b)
+		return err
+	})
+	if err == nil {
+		err = connection.Quit()
+	}
+	return
+}
+
+func TestRecordReplay(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/dir/a.txt", []byte("hello"))
+	s.Handle("AUTH", func(c *ftptest.Conn, arg string) {
+		c.Reply(334, "Send security data.")
+	})
+	s.Handle("ADAT", func(c *ftptest.Conn, arg string) {
+		c.Reply(235, "Security data exchange complete.")
+	})
+
+	var transcript bytes.Buffer
+	connection, err := Dial(s.Addr, DialRecord(&transcript), DialRecordData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = connection.Auth(&testMechanism{}); err != nil {
+		t.Fatal(err)
+	}
+	list, stat, file, err := session(connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adat := base64.StdEncoding.EncodeToString([]byte("response "))
+	if strings.Contains(transcript.String(), "secret") || strings.Contains(transcript.String(), adat) {
+		t.Errorf("password recorded:\n%s", transcript.String())
+	}
+
+	replayed, err := Replay(bytes.NewReader(transcript.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = replayed.Auth(&testMechanism{}); err != nil {
+		t.Fatalf("%v\n%s", err, transcript.String())
+	}
+	rlist, rstat, rfile, err := session(replayed)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, transcript.String())
+	}
+	if !reflect.DeepEqual(rlist, list) || !reflect.DeepEqual(rstat, stat) || rfile != file {
+		t.Errorf("replayed %q %q %q, recorded %q %q %q", rlist, rstat, rfile, list, stat, file)
+	}
+}
+
+func TestReplayBugReport(t *testing.T) {
+	const transcript = `# a server answering PASV without parentheses, and listing in DOS format
+< 220-Welcome
+< 220 Ready
+> USER anonymous
+< 331 Password required
+> PASS ****
+< 230 Logged in
+> TYPE A
+< 200 Type set to A
+> PASV
+< 227 Entering Passive Mode (10,0,0,1,4,1).
+> MLSD /
+D open
+D< "09-12-15  04:07AM             37192705 all.zip\r\n"
+D close
+< 150 Opening data connection
+< 226 Transfer complete
+> STAT /all.zip
+< 213-status of /all.zip:
+<     09-12-15  04:07AM             37192705 all.zip
+< 213 End of status.
+`
+	connection, err := Replay(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.Login("anonymous", "anything"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := connection.List("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || !strings.HasSuffix(list[0], " all.zip\r\n") {
+		t.Errorf("List = %q", list)
+	}
+	stat, err := connection.Stat("/all.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stat) == 0 || !strings.HasSuffix(stat[0], " all.zip") {
+		t.Errorf("Stat = %q", stat)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	const transcript = `< 220 Ready
+> CWD /a
+< 250 Done
+`
+	connection, err := Replay(strings.NewReader(transcript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if err = connection.Cwd("/b"); err == nil || !strings.HasPrefix(err.Error(), "421 ") {
+		t.Errorf("Cwd with another path = %v, want 421 reply", err)
+	}
+
+	if _, err = Replay(strings.NewReader("> NOOP\n")); err == nil {
+		t.Error("transcript without greeting replayed")
+	}
+}
@@ -0,0 +1,303 @@
+package goftp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+// TransferOption configures a transfer made with Stor, Retr, RetrFrom, Open
+// or Create
+type TransferOption func(*transfer)
+
+// TransferType sets the representation type of the transfer, TypeImage by
+// default. With TypeASCII local LF line endings are sent as CRLF, and
+// received CRLF line endings are turned into LF. TypeEBCDIC additionally
+// translates between Latin-1 and EBCDIC (code page 037), lines ending with
+// the EBCDIC NL character.
+func TransferType(t TypeCode) TransferOption {
+	return func(tr *transfer) {
+		tr.typ = t
+	}
+}
+
+type transfer struct {
+	typ TypeCode
+}
+
+// newTransfer returns the transfer configured by opts, of type typ unless
+// set, or TypeImage when empty
+func newTransfer(typ TypeCode, opts []TransferOption) *transfer {
+	if typ == "" {
+		typ = TypeImage
+	}
+	tr := &transfer{typ: typ}
+	for _, opt := range opts {
+		opt(tr)
+	}
+	return tr
+}
+
+// reader translates data received over conn
+func (tr *transfer) reader(conn io.Reader) io.Reader {
+	switch tr.typ {
+	case TypeASCII, TypeEBCDIC:
+		return &textReader{r: bufio.NewReader(conn), ebcdic: tr.typ == TypeEBCDIC}
+	}
+	return conn
+}
+
+// writer translates data sent over conn. The writer must be flushed once
+// done.
+func (tr *transfer) writer(conn io.Writer) flushWriter {
+	switch tr.typ {
+	case TypeASCII, TypeEBCDIC:
+		return &textWriter{w: conn, ebcdic: tr.typ == TypeEBCDIC}
+	}
+	return nopFlusher{conn}
+}
+
+type flushWriter interface {
+	io.Writer
+	Flush() error
+}
+
+type nopFlusher struct {
+	io.Writer
+}
+
+func (nopFlusher) Flush() error { return nil }
+
+// textReader turns the CRLF line endings of the network into LF, and
+// EBCDIC into Latin-1
+type textReader struct {
+	r      *bufio.Reader
+	ebcdic bool
+}
+
+func (t *textReader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if n > 0 && t.r.Buffered() == 0 {
+			// Don't wait for more data
+			break
+		}
+
+		b, err := t.r.ReadByte()
+		if err != nil {
+			if n > 0 {
+				break
+			}
+			return 0, err
+		}
+		if t.ebcdic {
+			b = fromEBCDIC[b]
+		}
+
+		if b == '\r' {
+			if next, err := t.r.Peek(1); err == nil && t.decode(next[0]) == '\n' {
+				continue
+			}
+		}
+
+		p[n] = b
+		n++
+	}
+	return n, nil
+}
+
+func (t *textReader) decode(b byte) byte {
+	if t.ebcdic {
+		return fromEBCDIC[b]
+	}
+	return b
+}
+
+// textWriter turns LF line endings into the CRLF of the network, or into
+// the NL of EBCDIC along with translating Latin-1 into EBCDIC
+type textWriter struct {
+	w      io.Writer
+	ebcdic bool
+	cr     bool
+	buf    []byte
+}
+
+func (t *textWriter) Write(p []byte) (int, error) {
+	t.buf = t.buf[:0]
+
+	for _, b := range p {
+		if !t.ebcdic {
+			if b == '\n' && !t.cr {
+				t.buf = append(t.buf, '\r')
+			}
+			t.buf = append(t.buf, b)
+			t.cr = b == '\r'
+			continue
+		}
+
+		// A CR is held until the next byte tells whether it ends a line,
+		// CRLF being a single NL
+		if t.cr && b != '\n' {
+			t.buf = append(t.buf, toEBCDIC['\r'])
+		}
+		if t.cr = b == '\r'; !t.cr {
+			t.buf = append(t.buf, toEBCDIC[b])
+		}
+	}
+
+	if _, err := t.w.Write(t.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes a CR held back at the end of the data
+func (t *textWriter) Flush() error {
+	if t.ebcdic && t.cr {
+		t.cr = false
+		_, err := t.w.Write([]byte{toEBCDIC['\r']})
+		return err
+	}
+	return nil
+}
+
+// openData negotiates a data connection and sends command, which
+// must be answered with a preliminary reply
+func (ftp *FTP) openData(typ TypeCode, command string, args ...interface{}) (net.Conn, error) {
+	if err := ftp.Type(typ); err != nil {
+		return nil, err
+	}
+
+	open, err := ftp.prepareData()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = ftp.send(command, args...); err != nil {
+		return nil, err
+	}
+
+	conn, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	// The final reply may arrive along with this one
+	line, err := ftp.receiveNoDiscard()
+	if err == nil && !strings.HasPrefix(line, "1") {
+		err = errors.New(line)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// closeData closes the data connection and reads the final reply of the
+// transfer
+func (ftp *FTP) closeData(conn net.Conn) error {
+	conn.Close()
+
+	line, err := ftp.receive()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, StatusClosingDataConnection) {
+		return errors.New(line)
+	}
+	return nil
+}
+
+// Open retrieves the file at path as a stream. The session can't be used
+// for anything else until the returned reader is closed. Closing it before
+// the end of the file aborts the transfer, which the server usually
+// reports as an error.
+func (ftp *FTP) Open(path string, opts ...TransferOption) (io.ReadCloser, error) {
+	tr := newTransfer(ftp.transferType, opts)
+
+	conn, err := ftp.openData(tr.typ, "RETR %s", path)
+	if err != nil {
+		return nil, err
+	}
+	return &dataReader{Reader: tr.reader(conn), ftp: ftp, conn: conn}, nil
+}
+
+type dataReader struct {
+	io.Reader
+	ftp  *FTP
+	conn net.Conn
+}
+
+func (r *dataReader) Close() error {
+	return r.ftp.closeData(r.conn)
+}
+
+// Create stores a file at path from what is written to the returned writer.
+// The session can't be used for anything else until the writer is closed,
+// which completes the transfer.
+func (ftp *FTP) Create(path string, opts ...TransferOption) (io.WriteCloser, error) {
+	tr := newTransfer(ftp.transferType, opts)
+
+	conn, err := ftp.openData(tr.typ, "STOR %s", path)
+	if err != nil {
+		return nil, err
+	}
+	return &dataWriter{flushWriter: tr.writer(conn), ftp: ftp, conn: conn}, nil
+}
+
+type dataWriter struct {
+	flushWriter
+	ftp  *FTP
+	conn net.Conn
+}
+
+func (w *dataWriter) Close() error {
+	err := w.Flush()
+	if cerr := w.ftp.closeData(w.conn); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// fromEBCDIC maps code page 037 to Latin-1. NL and LF are swapped, as is
+// usual for text, so that NL ends lines.
+var fromEBCDIC = [256]byte{
+	0x00, 0x01, 0x02, 0x03, 0x9c, 0x09, 0x86, 0x7f, 0x97, 0x8d, 0x8e, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	0x10, 0x11, 0x12, 0x13, 0x9d, 0x0a, 0x08, 0x87, 0x18, 0x19, 0x92, 0x8f, 0x1c, 0x1d, 0x1e, 0x1f,
+	0x80, 0x81, 0x82, 0x83, 0x84, 0x85, 0x17, 0x1b, 0x88, 0x89, 0x8a, 0x8b, 0x8c, 0x05, 0x06, 0x07,
+	0x90, 0x91, 0x16, 0x93, 0x94, 0x95, 0x96, 0x04, 0x98, 0x99, 0x9a, 0x9b, 0x14, 0x15, 0x9e, 0x1a,
+	0x20, 0xa0, 0xe2, 0xe4, 0xe0, 0xe1, 0xe3, 0xe5, 0xe7, 0xf1, 0xa2, 0x2e, 0x3c, 0x28, 0x2b, 0x7c,
+	0x26, 0xe9, 0xea, 0xeb, 0xe8, 0xed, 0xee, 0xef, 0xec, 0xdf, 0x21, 0x24, 0x2a, 0x29, 0x3b, 0xac,
+	0x2d, 0x2f, 0xc2, 0xc4, 0xc0, 0xc1, 0xc3, 0xc5, 0xc7, 0xd1, 0xa6, 0x2c, 0x25, 0x5f, 0x3e, 0x3f,
+	0xf8, 0xc9, 0xca, 0xcb, 0xc8, 0xcd, 0xce, 0xcf, 0xcc, 0x60, 0x3a, 0x23, 0x40, 0x27, 0x3d, 0x22,
+	0xd8, 0x61, 0x62, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69, 0xab, 0xbb, 0xf0, 0xfd, 0xfe, 0xb1,
+	0xb0, 0x6a, 0x6b, 0x6c, 0x6d, 0x6e, 0x6f, 0x70, 0x71, 0x72, 0xaa, 0xba, 0xe6, 0xb8, 0xc6, 0xa4,
+	0xb5, 0x7e, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0xa1, 0xbf, 0xd0, 0xdd, 0xde, 0xae,
+	0x5e, 0xa3, 0xa5, 0xb7, 0xa9, 0xa7, 0xb6, 0xbc, 0xbd, 0xbe, 0x5b, 0x5d, 0xaf, 0xa8, 0xb4, 0xd7,
+	0x7b, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49, 0xad, 0xf4, 0xf6, 0xf2, 0xf3, 0xf5,
+	0x7d, 0x4a, 0x4b, 0x4c, 0x4d, 0x4e, 0x4f, 0x50, 0x51, 0x52, 0xb9, 0xfb, 0xfc, 0xf9, 0xfa, 0xff,
+	0x5c, 0xf7, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0xb2, 0xd4, 0xd6, 0xd2, 0xd3, 0xd5,
+	0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0xb3, 0xdb, 0xdc, 0xd9, 0xda, 0x9f,
+}
+
+// toEBCDIC is the inverse of fromEBCDIC
+var toEBCDIC = [256]byte{
+	0x00, 0x01, 0x02, 0x03, 0x37, 0x2d, 0x2e, 0x2f, 0x16, 0x05, 0x15, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f,
+	0x10, 0x11, 0x12, 0x13, 0x3c, 0x3d, 0x32, 0x26, 0x18, 0x19, 0x3f, 0x27, 0x1c, 0x1d, 0x1e, 0x1f,
+	0x40, 0x5a, 0x7f, 0x7b, 0x5b, 0x6c, 0x50, 0x7d, 0x4d, 0x5d, 0x5c, 0x4e, 0x6b, 0x60, 0x4b, 0x61,
+	0xf0, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8, 0xf9, 0x7a, 0x5e, 0x4c, 0x7e, 0x6e, 0x6f,
+	0x7c, 0xc1, 0xc2, 0xc3, 0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xd1, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6,
+	0xd7, 0xd8, 0xd9, 0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xba, 0xe0, 0xbb, 0xb0, 0x6d,
+	0x79, 0x81, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89, 0x91, 0x92, 0x93, 0x94, 0x95, 0x96,
+	0x97, 0x98, 0x99, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xa9, 0xc0, 0x4f, 0xd0, 0xa1, 0x07,
+	0x20, 0x21, 0x22, 0x23, 0x24, 0x25, 0x06, 0x17, 0x28, 0x29, 0x2a, 0x2b, 0x2c, 0x09, 0x0a, 0x1b,
+	0x30, 0x31, 0x1a, 0x33, 0x34, 0x35, 0x36, 0x08, 0x38, 0x39, 0x3a, 0x3b, 0x04, 0x14, 0x3e, 0xff,
+	0x41, 0xaa, 0x4a, 0xb1, 0x9f, 0xb2, 0x6a, 0xb5, 0xbd, 0xb4, 0x9a, 0x8a, 0x5f, 0xca, 0xaf, 0xbc,
+	0x90, 0x8f, 0xea, 0xfa, 0xbe, 0xa0, 0xb6, 0xb3, 0x9d, 0xda, 0x9b, 0x8b, 0xb7, 0xb8, 0xb9, 0xab,
+	0x64, 0x65, 0x62, 0x66, 0x63, 0x67, 0x9e, 0x68, 0x74, 0x71, 0x72, 0x73, 0x78, 0x75, 0x76, 0x77,
+	0xac, 0x69, 0xed, 0xee, 0xeb, 0xef, 0xec, 0xbf, 0x80, 0xfd, 0xfe, 0xfb, 0xfc, 0xad, 0xae, 0x59,
+	0x44, 0x45, 0x42, 0x46, 0x43, 0x47, 0x9c, 0x48, 0x54, 0x51, 0x52, 0x53, 0x58, 0x55, 0x56, 0x57,
+	0x8c, 0x49, 0xcd, 0xce, 0xcb, 0xcf, 0xcc, 0xe1, 0x70, 0xdd, 0xde, 0xdb, 0xdc, 0x8d, 0x8e, 0xdf,
+}
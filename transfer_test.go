@@ -0,0 +1,115 @@
+package goftp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestTextTranslation(t *testing.T) {
+	for _, test := range []struct {
+		typ            TypeCode
+		local, network string
+	}{
+		{TypeASCII, "a\nb\r\nc\r\r\n\n", "a\r\nb\r\nc\r\r\n\r\n"},
+		{TypeEBCDIC, "Hi\r\n0 \n\r", "\xc8\x89\x15\xf0\x40\x15\x0d"},
+	} {
+		tr := newTransfer(TypeImage, []TransferOption{TransferType(test.typ)})
+
+		// One byte at a time, to cross every buffer boundary
+		var network bytes.Buffer
+		w := tr.writer(&network)
+		for i := 0; i < len(test.local); i++ {
+			w.Write([]byte{test.local[i]})
+		}
+		w.Flush()
+		if network.String() != test.network {
+			t.Errorf("TYPE %s: sent %q, want %q", test.typ, network.String(), test.network)
+		}
+
+		local, err := io.ReadAll(tr.reader(iotest.OneByteReader(strings.NewReader(test.network))))
+		want := strings.Replace(test.local, "\r\n", "\n", -1)
+		if err != nil || string(local) != want {
+			t.Errorf("TYPE %s: received %q, %v, want %q", test.typ, local, err, want)
+		}
+	}
+}
+
+func TestStorRetrASCII(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	if err := connection.Stor("/text", strings.NewReader("one\ntwo\n"), TransferType(TypeASCII)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.ReadFile("/text"); string(got) != "one\r\ntwo\r\n" {
+		t.Errorf("stored %q", got)
+	}
+
+	var got []byte
+	if _, err := connection.Retr("/text", func(r io.Reader) (err error) {
+		got, err = io.ReadAll(r)
+		return
+	}, TransferType(TypeASCII)); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "one\ntwo\n" {
+		t.Errorf("retrieved %q", got)
+	}
+
+	var typ string
+	for _, cmd := range s.Commands() {
+		if strings.HasPrefix(cmd, "TYPE ") {
+			typ = cmd
+		}
+	}
+	if typ != "TYPE A" {
+		t.Errorf("last type command = %q", typ)
+	}
+}
+
+func TestOpenCreate(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	w, err := connection.Create("/file", TransferType(TypeEBCDIC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "Hi\n")
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.ReadFile("/file"); string(got) != "\xc8\x89\x15" {
+		t.Errorf("stored %q", got)
+	}
+
+	r, err := connection.Open("/file", TransferType(TypeEBCDIC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil || string(got) != "Hi\n" {
+		t.Errorf("read %q, %v", got, err)
+	}
+	if err = r.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = connection.Open("/missing"); err == nil {
+		t.Error("Open of a missing file succeeded")
+	}
+	if _, err = connection.Pwd(); err != nil {
+		t.Errorf("session unusable: %v", err)
+	}
+}
@@ -2,97 +2,410 @@
 package goftp
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
 )
 
-func (ftp *FTP) copyDir(localPath string) error {
-	fullPath, err := filepath.Abs(localPath)
-	if err != nil {
-		return err
+// UploadOption configures Upload
+type UploadOption func(*uploader)
+
+// UploadTo sets the remote directory the file, or the contents of the
+// directory, are uploaded into. It is created when missing. The current
+// directory is used by default.
+func UploadTo(remotePath string) UploadOption {
+	return func(u *uploader) {
+		u.target = remotePath
+	}
+}
+
+// UploadInclude restricts the uploaded files to those matching one of the
+// patterns. Patterns use path.Match syntax and are matched against the base
+// name, or against the slash separated relative path when they contain a
+// slash, which is the base name for a single file. Directories are always
+// descended into.
+func UploadInclude(patterns ...string) UploadOption {
+	return func(u *uploader) {
+		u.include = append(u.include, patterns...)
+	}
+}
+
+// UploadExclude leaves out files and directories matching one of the
+// patterns, such as ".git" or "*.tmp". Patterns are matched like those of
+// UploadInclude; an excluded directory is skipped with all its contents.
+func UploadExclude(patterns ...string) UploadOption {
+	return func(u *uploader) {
+		u.exclude = append(u.exclude, patterns...)
+	}
+}
+
+// UploadConflict sets what happens to remote files that already exist,
+// Overwrite by default
+func UploadConflict(policy Conflict) UploadOption {
+	return func(u *uploader) {
+		u.conflict = policy
 	}
+}
+
+// UploadFollowSymlinks sets whether symlinks are uploaded as the file or
+// directory they point to, which is the default, or left out
+func UploadFollowSymlinks(follow bool) UploadOption {
+	return func(u *uploader) {
+		u.noFollow = !follow
+	}
+}
+
+// UploadAtomic stores each file under a temporary name, formed by adding
+// prefix and suffix to its base name, verifies its size and renames it into
+// place, so that files never appear half written. A partial file is removed
+// when the transfer fails. Servers refusing to rename over a file have it
+// deleted first; if the rename then fails the temporary file is kept and
+// named in the error. With an empty prefix and suffix a hidden dot-file
+// ending in ".part" is used.
+func UploadAtomic(prefix, suffix string) UploadOption {
+	return func(u *uploader) {
+		if prefix == "" && suffix == "" {
+			prefix, suffix = ".", ".part"
+		}
+		u.atomic = true
+		u.tempPrefix, u.tempSuffix = prefix, suffix
+	}
+}
+
+// UploadVerify hashes each file while it is uploaded and compares the sum
+// with the one the server computes, using the strongest algorithm the server
+// supports. Uploads fail with ErrHashUnsupported when it supports none.
+func UploadVerify() UploadOption {
+	return func(u *uploader) {
+		u.verify = true
+	}
+}
 
-	pwd, err := ftp.Pwd()
+// UploadPreserveMode applies the permissions of local files to the remote
+// files with SITE CHMOD. It stops trying, without failing the upload, when
+// the server doesn't implement SITE CHMOD.
+func UploadPreserveMode() UploadOption {
+	return func(u *uploader) {
+		u.preserveMode = true
+	}
+}
+
+type uploader struct {
+	ftp      *FTP
+	target   string
+	include  []string
+	exclude  []string
+	conflict Conflict
+	noFollow bool
+
+	verify                 bool
+	preserveMode, noChmod  bool
+	atomic                 bool
+	tempPrefix, tempSuffix string
+
+	// names caches the listing of remote directories, to detect conflicts
+	names map[string]map[string]bool
+
+	// parents holds the local directories being uploaded, to stop at
+	// symlink loops
+	parents map[string]bool
+}
+
+// Upload a file, or recursively upload a directory.
+// Only normal files and directories are uploaded.
+// Symlinks are not kept but treated as normal files/directories if targets are so.
+func (ftp *FTP) Upload(localPath string, opts ...UploadOption) (err error) {
+	u := &uploader{ftp: ftp, names: map[string]map[string]bool{}, parents: map[string]bool{}}
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	for _, pattern := range append(u.include, u.exclude...) {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+
+	fInfo, err := os.Stat(localPath)
 	if err != nil {
 		return err
 	}
+	if name := filepath.Base(localPath); !fInfo.IsDir() && !u.selected(name, name) {
+		return nil
+	}
 
-	walkFunc := func(path string, fi os.FileInfo, err error) error {
-		// Stop upon error
-		if err != nil {
+	if u.target == "" {
+		if u.target, err = ftp.Pwd(); err != nil {
 			return err
 		}
-		relPath, err := filepath.Rel(fullPath, path)
-		if err != nil {
-			return err
+	} else if err = ftp.MkdirAll(u.target); err != nil {
+		return err
+	}
+
+	switch {
+	case fInfo.IsDir():
+		return u.dir(localPath, u.target, "")
+	case fInfo.Mode()&os.ModeType == 0:
+		return u.file(localPath, path.Join(u.target, filepath.Base(localPath)))
+	default:
+		// Ignore other special files
+	}
+
+	return nil
+}
+
+func (u *uploader) dir(localPath, remotePath, rel string) error {
+	if real, err := filepath.EvalSymlinks(localPath); err == nil {
+		if u.parents[real] {
+			return nil
 		}
-		switch {
-		case fi.IsDir():
-			// Walk calls walkFn on root as well
-			if path == fullPath {
-				return nil
-			}
-			if err = ftp.Mkd(relPath); err != nil {
-				if _, err = ftp.List(relPath + "/"); err != nil {
-					return err
-				}
+		u.parents[real] = true
+		defer delete(u.parents, real)
+	}
+
+	entries, err := os.ReadDir(localPath)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range entries {
+		name := d.Name()
+		local, remote, childRel := filepath.Join(localPath, name), path.Join(remotePath, name), path.Join(rel, name)
+
+		if u.matches(u.exclude, name, childRel) {
+			continue
+		}
+
+		mode := d.Type()
+		if mode&os.ModeSymlink != 0 {
+			if u.noFollow {
+				continue
 			}
-		case fi.Mode()&os.ModeSymlink == os.ModeSymlink:
-			fInfo, err := os.Stat(path)
+			fi, err := os.Stat(local)
 			if err != nil {
 				return err
 			}
-			if fInfo.IsDir() {
-				err = ftp.Mkd(relPath)
+			mode = fi.Mode().Type()
+		}
+
+		switch {
+		case mode.IsDir():
+			if err = u.mkdir(remote); err != nil {
 				return err
-			} else if fInfo.Mode()&os.ModeType != 0 {
-				// ignore other special files
-				return nil
 			}
-			fallthrough
-		case fi.Mode()&os.ModeType == 0:
-			if err = ftp.copyFile(path, pwd+"/"+relPath); err != nil {
+			if err = u.dir(local, remote, childRel); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if !u.selected(name, childRel) {
+				continue
+			}
+			if err = u.file(local, remote); err != nil {
 				return err
 			}
 		default:
 			// Ignore other special files
 		}
+	}
+
+	return nil
+}
+
+// selected reports whether the file name, at rel, passes the include and
+// exclude patterns
+func (u *uploader) selected(name, rel string) bool {
+	if u.matches(u.exclude, name, rel) {
+		return false
+	}
+	return len(u.include) == 0 || u.matches(u.include, name, rel)
+}
+
+// matches reports whether one of patterns matches name or rel
+func (u *uploader) matches(patterns []string, name, rel string) bool {
+	for _, pattern := range patterns {
+		subject := name
+		if path.Base(pattern) != pattern {
+			subject = rel
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+	return false
+}
 
+// mkdir creates the remote directory p, unless it already exists
+func (u *uploader) mkdir(p string) error {
+	if err := u.ftp.Mkd(p); err != nil {
+		if ok, _ := u.ftp.isDir(p); !ok {
+			return err
+		}
 		return nil
 	}
 
-	return filepath.Walk(fullPath, walkFunc)
+	u.names[p] = map[string]bool{}
+	return nil
 }
 
-func (ftp *FTP) copyFile(localPath, serverPath string) (err error) {
-	var file *os.File
-	if file, err = os.Open(localPath); err != nil {
+// exists reports whether the remote file p exists, listing its directory
+// once
+func (u *uploader) exists(p string) (bool, error) {
+	dir := path.Dir(p)
+
+	names, ok := u.names[dir]
+	if !ok {
+		entries, err := u.ftp.ListEntries(dir)
+		if err != nil {
+			return false, err
+		}
+
+		names = map[string]bool{}
+		for _, e := range entries {
+			names[e.Name] = true
+		}
+		u.names[dir] = names
+	}
+
+	return names[path.Base(p)], nil
+}
+
+func (u *uploader) file(localPath, remotePath string) error {
+	if u.conflict != Overwrite {
+		exists, err := u.exists(remotePath)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case !exists:
+		case u.conflict == Skip:
+			return nil
+		case u.conflict == Rename:
+			dir, name := path.Dir(remotePath), path.Base(remotePath)
+			for n := 1; exists; n++ {
+				remotePath = path.Join(dir, conflictName(name, n))
+				if exists, err = u.exists(remotePath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	store := u.put
+	if u.atomic {
+		store = u.storeAtomic
+	}
+	if err := store(localPath, remotePath); err != nil {
+		return err
+	}
+
+	if u.preserveMode && !u.noChmod {
+		fi, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		if err = u.ftp.Chmod(remotePath, fi.Mode()); isNotImplemented(err) {
+			u.noChmod = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if names := u.names[path.Dir(remotePath)]; names != nil {
+		names[path.Base(remotePath)] = true
+	}
+	return nil
+}
+
+// put uploads localPath to remotePath, verifying it when asked to
+func (u *uploader) put(localPath, remotePath string) error {
+	if !u.verify {
+		return u.ftp.copyFile(localPath, remotePath)
+	}
+
+	algorithm, err := u.ftp.verifyAlgorithm()
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(localPath)
+	if err != nil {
 		return err
 	}
 	defer file.Close()
-	if err := ftp.Stor(serverPath, file); err != nil {
+
+	h := newHash(algorithm)
+	if err = u.ftp.Stor(remotePath, io.TeeReader(file, h)); err != nil {
+		return err
+	}
+	return u.ftp.verifyHash(remotePath, algorithm, h)
+}
+
+// storeAtomic uploads localPath to a temporary name next to remotePath and
+// renames it into place once its size is verified
+func (u *uploader) storeAtomic(localPath, remotePath string) error {
+	fi, err := os.Stat(localPath)
+	if err != nil {
 		return err
 	}
 
+	temp := path.Join(path.Dir(remotePath), u.tempPrefix+path.Base(remotePath)+u.tempSuffix)
+	if err = u.put(localPath, temp); err != nil {
+		// Clean up whatever part of the file the server kept
+		u.ftp.Dele(temp)
+		return err
+	}
+
+	size, err := u.ftp.remoteSize(temp)
+	if err == nil && size != fi.Size() {
+		err = fmt.Errorf("size mismatch uploading %s: %d bytes stored, %d expected", remotePath, size, fi.Size())
+	}
+	if err != nil {
+		u.ftp.Dele(temp)
+		return err
+	}
+
+	if err = u.ftp.Rename(temp, remotePath); err == nil {
+		return nil
+	}
+
+	// Some servers refuse to rename over an existing file
+	if u.ftp.Dele(remotePath) != nil {
+		// The destination is intact, drop the upload
+		u.ftp.Dele(temp)
+		return err
+	}
+	if err = u.ftp.Rename(temp, remotePath); err != nil {
+		// The destination is gone, so the upload is the only copy left
+		return fmt.Errorf("renaming %s to %s: %w; the upload is kept as %s", temp, remotePath, err, temp)
+	}
 	return nil
 }
 
-// Upload a file, or recursively upload a directory.
-// Only normal files and directories are uploaded.
-// Symlinks are not kept but treated as normal files/directories if targets are so.
-func (ftp *FTP) Upload(localPath string) (err error) {
-	fInfo, err := os.Stat(localPath)
+// remoteSize returns the size of the remote file p, with SIZE or else from
+// its listing entry
+func (ftp *FTP) remoteSize(p string) (int64, error) {
+	if size, err := ftp.Size(p); err == nil {
+		return int64(size), nil
+	}
+
+	e, err := ftp.statEntry(p)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return e.Size, nil
+}
 
-	switch {
-	case fInfo.IsDir():
-		return ftp.copyDir(localPath)
-	case fInfo.Mode()&os.ModeType == 0:
-		return ftp.copyFile(localPath, filepath.Base(localPath))
-	default:
-		// Ignore other special files
+func (ftp *FTP) copyFile(localPath, serverPath string) (err error) {
+	var file *os.File
+	if file, err = os.Open(localPath); err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := ftp.Stor(serverPath, file); err != nil {
+		return err
 	}
 
 	return nil
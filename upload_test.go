@@ -0,0 +1,272 @@
+package goftp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestUploadTo(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{
+		"a.txt":       "a",
+		"b.tmp":       "b",
+		"sub/c.txt":   "c",
+		".git/config": "git",
+	}, time.Now())
+
+	if err := connection.Upload(local, UploadTo("/target"), UploadExclude(".git", "*.tmp")); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]bool{
+		"/target/a.txt":       true,
+		"/target/sub/c.txt":   true,
+		"/target/b.tmp":       false,
+		"/target/.git":        false,
+		"/target/.git/config": false,
+	} {
+		if exists, _ := s.Stat(name); exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+
+	if err := connection.Upload(filepath.Join(local, "b.tmp"), UploadTo("/single")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.ReadFile("/single/b.tmp"); string(got) != "b" {
+		t.Errorf("/single/b.tmp = %q", got)
+	}
+}
+
+func TestUploadInclude(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"a.go": "a", "a.txt": "a", "sub/b.go": "b", "sub/b.txt": "b"}, time.Now())
+
+	if err := connection.Upload(local, UploadTo("/target"), UploadInclude("*.go")); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{"/target/a.go": true, "/target/sub/b.go": true, "/target/a.txt": false, "/target/sub/b.txt": false} {
+		if exists, _ := s.Stat(name); exists != want {
+			t.Errorf("%s exists = %v, want %v", name, exists, want)
+		}
+	}
+
+	// A single file is filtered by its base name
+	for _, opt := range []UploadOption{UploadInclude("*.go"), UploadExclude("*.txt")} {
+		if err := connection.Upload(filepath.Join(local, "a.txt"), UploadTo("/single"), opt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if exists, _ := s.Stat("/single/a.txt"); exists {
+		t.Error("filtered out single file uploaded")
+	}
+	if err := connection.Upload(filepath.Join(local, "a.go"), UploadTo("/single"), UploadInclude("*.go")); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := s.Stat("/single/a.go"); !exists {
+		t.Error("included single file not uploaded")
+	}
+
+	if err := connection.Upload(local, UploadInclude("[")); err == nil {
+		t.Error("bad pattern accepted")
+	}
+}
+
+func TestUploadConflict(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/target/a.txt", []byte("remote"))
+	s.WriteFile("/target/a (1).txt", []byte("remote"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"a.txt": "local"}, time.Now())
+
+	if err := connection.Upload(local, UploadTo("/target"), UploadConflict(Skip)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.ReadFile("/target/a.txt"); string(got) != "remote" {
+		t.Errorf("Skip overwrote the remote file: %q", got)
+	}
+
+	if err := connection.Upload(local, UploadTo("/target"), UploadConflict(Rename)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.ReadFile("/target/a (2).txt"); string(got) != "local" {
+		t.Errorf("Rename wrote %q to a (2).txt", got)
+	}
+	if got := conflictName("a.tar.gz", 1); got != "a.tar (1).gz" {
+		t.Errorf("conflictName(a.tar.gz) = %q", got)
+	}
+
+	if err := connection.Upload(local, UploadTo("/target")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.ReadFile("/target/a.txt"); string(got) != "local" {
+		t.Errorf("Overwrite kept the remote file: %q", got)
+	}
+}
+
+func TestUploadSymlinks(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"real/a.txt": "a"}, time.Now())
+	if err := os.Symlink("real", filepath.Join(local, "link")); err != nil {
+		t.Skip(err)
+	}
+	// A loop, which is not followed
+	if err := os.Symlink("..", filepath.Join(local, "real", "up")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := connection.Upload(local, UploadTo("/follow")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/follow/link/a.txt", "/follow/real/a.txt"} {
+		if got, _ := s.ReadFile(name); string(got) != "a" {
+			t.Errorf("%s = %q", name, got)
+		}
+	}
+	if exists, _ := s.Stat("/follow/real/up/real"); exists {
+		t.Error("symlink loop followed")
+	}
+
+	if err := connection.Upload(local, UploadTo("/nofollow"), UploadFollowSymlinks(false)); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := s.Stat("/nofollow/link"); exists {
+		t.Error("symlink uploaded without following")
+	}
+	if got, _ := s.ReadFile("/nofollow/real/a.txt"); string(got) != "a" {
+		t.Errorf("real/a.txt = %q", got)
+	}
+}
+
+func TestUploadAtomic(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"a.txt": "contents"}, time.Now())
+
+	if err := connection.Upload(filepath.Join(local, "a.txt"), UploadTo("/drop"), UploadAtomic("", "")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.ReadFile("/drop/a.txt"); string(got) != "contents" {
+		t.Errorf("/drop/a.txt = %q", got)
+	}
+
+	if err := connection.Upload(filepath.Join(local, "a.txt"), UploadTo("/drop"), UploadAtomic("tmp-", ".upload")); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored []string
+	for _, cmd := range s.Commands() {
+		if strings.HasPrefix(cmd, "STOR ") {
+			stored = append(stored, cmd[5:])
+		}
+	}
+	if want := []string{"/drop/.a.txt.part", "/drop/tmp-a.txt.upload"}; !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored under %q, want %q", stored, want)
+	}
+	if exists, _ := s.Stat("/drop/tmp-a.txt.upload"); exists {
+		t.Error("temporary file left behind")
+	}
+}
+
+func TestUploadAtomicFailure(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"a.txt": "contents"}, time.Now())
+
+	s.Reply("SIZE", 213, "3")
+	if err := connection.Upload(filepath.Join(local, "a.txt"), UploadTo("/drop"), UploadAtomic("", "")); err == nil {
+		t.Error("size mismatch not detected")
+	}
+	if exists, _ := s.Stat("/drop/.a.txt.part"); exists {
+		t.Error("partial file left behind")
+	}
+	if exists, _ := s.Stat("/drop/a.txt"); exists {
+		t.Error("partial file renamed into place")
+	}
+
+	s.InjectFault("STOR", ftptest.Fault{Code: 451, Message: "Local error in processing."})
+	if err := connection.Upload(filepath.Join(local, "a.txt"), UploadTo("/drop"), UploadAtomic("", "")); err == nil {
+		t.Error("failed transfer not reported")
+	}
+
+	commands := s.Commands()
+	if last := commands[len(commands)-1]; last != "DELE /drop/.a.txt.part" {
+		t.Errorf("last command = %q, want clean up", last)
+	}
+}
+
+func TestUploadAtomicRenameFailure(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/drop/a.txt", []byte("old"))
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	local := t.TempDir()
+	writeLocal(t, local, map[string]string{"a.txt": "new contents"}, time.Now())
+
+	// The rename over the file and the rename after deleting it both fail
+	s.InjectFault("RNTO", ftptest.Fault{Code: 553, Message: "Rename refused.", Times: 2})
+	err := connection.Upload(filepath.Join(local, "a.txt"), UploadTo("/drop"), UploadAtomic("", ""))
+	if err == nil || !strings.Contains(err.Error(), "kept as /drop/.a.txt.part") {
+		t.Errorf("Upload = %v, want the temporary file named", err)
+	}
+	if got, err := s.ReadFile("/drop/.a.txt.part"); string(got) != "new contents" {
+		t.Errorf("kept upload = %q, %v", got, err)
+	}
+
+	// When the destination can't be deleted it stays, and the upload goes
+	s.ClearFaults()
+	s.WriteFile("/drop/a.txt", []byte("old"))
+	s.InjectFault("RNTO", ftptest.Fault{Code: 553, Message: "Rename refused.", Times: 1})
+	s.InjectFault("DELE", ftptest.Fault{Code: 550, Message: "Permission denied.", Times: 1})
+	if err := connection.Upload(filepath.Join(local, "a.txt"), UploadTo("/drop"), UploadAtomic("", "")); err == nil {
+		t.Error("failed rename not reported")
+	}
+	if got, _ := s.ReadFile("/drop/a.txt"); string(got) != "old" {
+		t.Errorf("/drop/a.txt = %q, want the old file", got)
+	}
+	if exists, _ := s.Stat("/drop/.a.txt.part"); exists {
+		t.Error("temporary file left behind")
+	}
+}
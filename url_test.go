@@ -0,0 +1,121 @@
+package goftp
+
+import (
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func TestDialURL(t *testing.T) {
+	s := ftptest.NewUnstartedServer()
+	s.Users = map[string]string{"us er": "p@ss:"}
+	s.Start()
+	defer s.Close()
+	s.WriteFile("/dir/file.txt", []byte("line\n"))
+
+	connection, err := DialURL("ftp://us%20er:p%40ss%3A@" + s.Addr + "/dir/file.txt;type=a?mode=active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+
+	if dir, err := connection.Pwd(); err != nil || dir != "/dir" {
+		t.Errorf("Pwd = %q, %v", dir, err)
+	}
+
+	var got []byte
+	if _, err = connection.Retr("file.txt", func(r io.Reader) (err error) {
+		got, err = ioutil.ReadAll(r)
+		return
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "line\n" {
+		t.Errorf("Retr = %q", got)
+	}
+
+	// The server never connects for a failed command
+	if _, err = connection.Retr("missing", func(r io.Reader) error { return nil }); err == nil {
+		t.Error("Retr of a missing file succeeded")
+	}
+
+	commands := strings.Join(s.Commands(), "|")
+	if !strings.Contains(commands, "TYPE A|PORT ") || strings.Contains(commands, "PASV") {
+		t.Errorf("commands = %s, want ASCII in active mode", commands)
+	}
+}
+
+func TestDialURLTLS(t *testing.T) {
+	for _, scheme := range []string{"ftpes", "ftps"} {
+		s := ftptest.NewUnstartedServer()
+		if scheme == "ftps" {
+			s.StartTLS()
+		} else {
+			s.Start()
+		}
+		s.WriteFile("/secret.txt", []byte("secret"))
+
+		connection, err := DialURL(scheme+"://"+s.Addr+"/", DialTLSConfig(s.ClientTLSConfig()))
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+
+		var got []byte
+		if _, err = connection.Retr("/secret.txt", func(r io.Reader) (err error) {
+			got, err = ioutil.ReadAll(r)
+			return
+		}); err != nil || string(got) != "secret" {
+			t.Errorf("%s: Retr = %q, %v", scheme, got, err)
+		}
+		if id := connection.Identity(); id.Mechanism != "TLS" || id.User != "anonymous" {
+			t.Errorf("%s: Identity = %+v", scheme, id)
+		}
+
+		connection.Close()
+		s.Close()
+	}
+
+	s := ftptest.NewServer()
+	defer s.Close()
+	if _, err := DialURL("ftpes://" + s.Addr + "/"); err == nil {
+		t.Error("untrusted certificate accepted")
+	}
+	connection, err := DialURL("ftpes://" + s.Addr + "/?insecure=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection.Close()
+}
+
+func TestURLPath(t *testing.T) {
+	for _, test := range []struct {
+		url, dir string
+		typ      TypeCode
+	}{
+		{"ftp://host", "", ""},
+		{"ftp://host/", "", ""},
+		{"ftp://host/pub/dir", "pub/dir", ""},
+		{"ftp://host/%2Fetc", "/etc", ""},
+		{"ftp://host/pub/a%20b;type=d", "pub/a b", ""},
+		{"ftp://host/pub/file.bin;type=i", "pub", TypeImage},
+		{"ftp://host/file.txt;type=A", "", TypeASCII},
+	} {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dir, typ, err := urlPath(u); err != nil || dir != test.dir || typ != test.typ {
+			t.Errorf("urlPath(%s) = %q, %q, %v, want %q, %q", test.url, dir, typ, err, test.dir, test.typ)
+		}
+	}
+
+	for _, bad := range []string{"http://host/", "ftp://host/;type=x", "ftp://host/?mode=fast", "ftp://host/?proxy=x"} {
+		if _, err := DialURL(bad); err == nil {
+			t.Errorf("DialURL(%s) succeeded", bad)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package goftp
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+)
+
+// Order is the order in which WalkDir visits a tree
+type Order int
+
+// Walk orders
+const (
+	// DepthFirst visits the contents of a directory before its siblings
+	DepthFirst Order = iota
+	// BreadthFirst visits a whole level of the tree before the next one
+	BreadthFirst
+)
+
+// WalkOption configures WalkDir
+type WalkOption func(*walker)
+
+// WalkOrder sets the order of the walk, DepthFirst by default
+func WalkOrder(order Order) WalkOption {
+	return func(w *walker) {
+		w.order = order
+	}
+}
+
+// WalkMaxDepth stops the walk from listing directories deeper than depth.
+// The root is at depth 0; directories at depth are reported but not listed.
+func WalkMaxDepth(depth int) WalkOption {
+	return func(w *walker) {
+		w.maxDepth = depth
+	}
+}
+
+type walker struct {
+	ftp      *FTP
+	fn       fs.WalkDirFunc
+	order    Order
+	maxDepth int
+
+	// ancestors holds the MLSD unique facts of the directories being
+	// walked depth first, to stop at symlink loops on servers that follow
+	// links
+	ancestors map[string]bool
+}
+
+// WalkDir walks the remote tree rooted at root, calling fn for each file or
+// directory in the tree, including root. It mirrors filepath.WalkDir:
+// directories are reported before their contents and entries are visited
+// in lexical order. fn may return fs.SkipDir to skip a directory (or the
+// rest of the current directory, when returned for a file) and fs.SkipAll
+// to stop the walk. When a directory can't be listed, fn is called a
+// second time for it with the error.
+//
+// Directories with the unique fact of one of their ancestors are reported
+// but not listed again, which breaks symlink loops on servers that support
+// MLSD. A directory reached by several links is walked under each of them.
+func (ftp *FTP) WalkDir(root string, fn fs.WalkDirFunc, opts ...WalkOption) error {
+	w := &walker{ftp: ftp, fn: fn, maxDepth: -1, ancestors: map[string]bool{}}
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	e, err := ftp.statEntry(root)
+	switch {
+	case err != nil:
+		err = fn(root, nil, err)
+	case w.order == BreadthFirst:
+		err = w.walkBreadthFirst(root, e)
+	default:
+		err = w.walkDepthFirst(root, e, 0)
+	}
+
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// list lists the directory e at depth, unless the depth limit stops it or
+// it loops back to an ancestor. A non-nil error is the result of reporting a
+// listing error to fn.
+func (w *walker) list(p string, e *Entry, depth int, loop bool) ([]*Entry, error) {
+	if loop || w.maxDepth >= 0 && depth >= w.maxDepth {
+		return nil, nil
+	}
+
+	if w.ftp.debug {
+		log.Printf("Walking: '%s'\n", p)
+	}
+
+	entries, err := w.ftp.ListEntries(p)
+	if err != nil {
+		// Second call, to report the listing error
+		return nil, w.fn(p, e.DirEntry(), err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+func (w *walker) walkDepthFirst(p string, e *Entry, depth int) error {
+	if err := w.fn(p, e.DirEntry(), nil); err != nil || !e.IsDir() {
+		if err == fs.SkipDir && e.IsDir() {
+			// Successfully skipped directory
+			err = nil
+		}
+		return err
+	}
+
+	unique := e.Facts["unique"]
+	loop := unique != "" && w.ancestors[unique]
+	entries, err := w.list(p, e, depth, loop)
+	if err != nil {
+		if err == fs.SkipDir {
+			err = nil
+		}
+		return err
+	}
+
+	if unique != "" && !loop {
+		w.ancestors[unique] = true
+		defer delete(w.ancestors, unique)
+	}
+
+	for _, child := range entries {
+		if err := w.walkDepthFirst(path.Join(p, child.Name), child, depth+1); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+type walkItem struct {
+	path   string
+	entry  *Entry
+	depth  int
+	parent int
+}
+
+func (w *walker) walkBreadthFirst(root string, e *Entry) error {
+	queue := []walkItem{{root, e, 0, -1}}
+	skipped := map[int]bool{}
+
+	for i := 0; i < len(queue); i++ {
+		item := queue[i]
+		if item.parent >= 0 && skipped[item.parent] {
+			continue
+		}
+
+		if err := w.fn(item.path, item.entry.DirEntry(), nil); err != nil {
+			if err != fs.SkipDir {
+				return err
+			}
+			if !item.entry.IsDir() {
+				// Skip the remaining entries of the parent directory
+				skipped[item.parent] = true
+			}
+			continue
+		}
+		if !item.entry.IsDir() {
+			continue
+		}
+
+		entries, err := w.list(item.path, item.entry, item.depth, loops(queue, i))
+		if err != nil {
+			if err != fs.SkipDir {
+				return err
+			}
+			continue
+		}
+
+		for _, child := range entries {
+			queue = append(queue, walkItem{path.Join(item.path, child.Name), child, item.depth + 1, i})
+		}
+	}
+	return nil
+}
+
+// loops reports whether the directory of queue[i] has the unique fact of
+// one of its ancestors
+func loops(queue []walkItem, i int) bool {
+	unique := queue[i].entry.Facts["unique"]
+	if unique == "" {
+		return false
+	}
+	for j := queue[i].parent; j >= 0; j = queue[j].parent {
+		if queue[j].entry.Facts["unique"] == unique {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk walks recursively through path and call walkfunc for each file.
+// Directories are not reported; use WalkDir for that.
+func (ftp *FTP) Walk(path string, walkFn WalkFunc) (err error) {
+	return ftp.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return walkFn(p, os.FileMode(0), err)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return walkFn(p, os.FileMode(0), err)
+		}
+		return walkFn(p, info.Mode(), nil)
+	})
+}
@@ -0,0 +1,244 @@
+package goftp
+
+import (
+	"io/fs"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/dutchcoders/goftp/ftptest"
+)
+
+func walkServer(t *testing.T) *ftptest.Server {
+	s := ftptest.NewServer()
+	s.WriteFile("/root/a.txt", []byte("a"))
+	s.WriteFile("/root/b/c.txt", []byte("cc"))
+	s.WriteFile("/root/b/d/e.txt", []byte("eee"))
+	s.WriteFile("/root/f/g.txt", []byte("gggg"))
+	return s
+}
+
+func TestWalkDir(t *testing.T) {
+	s := walkServer(t)
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	var visited []string
+	sizes := map[string]int64{}
+	err := connection.WalkDir("/root", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, p)
+
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			sizes[p] = info.Size()
+		}
+		if p == "/root/b/d" {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/root", "/root/a.txt", "/root/b", "/root/b/c.txt", "/root/b/d", "/root/f", "/root/f/g.txt"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("visited %q, want %q", visited, want)
+	}
+	if sizes["/root/b/c.txt"] != 2 || sizes["/root/f/g.txt"] != 4 {
+		t.Errorf("sizes = %v", sizes)
+	}
+}
+
+func TestWalkDirSkipAll(t *testing.T) {
+	s := walkServer(t)
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	var visited []string
+	err := connection.WalkDir("/root", func(p string, d fs.DirEntry, err error) error {
+		visited = append(visited, p)
+		if p == "/root/b/c.txt" {
+			return fs.SkipAll
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(visited) != 4 {
+		t.Errorf("visited %q after SkipAll", visited)
+	}
+}
+
+func TestWalkDirListError(t *testing.T) {
+	s := walkServer(t)
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	var failed []string
+	err := connection.WalkDir("/root", func(p string, d fs.DirEntry, err error) error {
+		if p == "/root/b" && d.IsDir() && err == nil {
+			s.InjectFault("MLSD", ftptest.Fault{Code: 450, Message: "Try again later", Times: 1})
+			s.InjectFault("LIST", ftptest.Fault{Code: 450, Message: "Try again later", Times: 1})
+		}
+		if err != nil {
+			failed = append(failed, p)
+			return nil
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(failed, []string{"/root/b"}) {
+		t.Errorf("errors reported for %q, want /root/b", failed)
+	}
+}
+
+func TestWalkDirMissingRoot(t *testing.T) {
+	s := walkServer(t)
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	var calls int
+	err := connection.WalkDir("/missing", func(p string, d fs.DirEntry, err error) error {
+		calls++
+		if d != nil || err == nil {
+			t.Errorf("fn(%q, %v, %v), want error for missing root", p, d, err)
+		}
+		return err
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("WalkDir = %v after %d calls", err, calls)
+	}
+}
+
+func TestWalkDirOptions(t *testing.T) {
+	s := walkServer(t)
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	tests := []struct {
+		opts []WalkOption
+		want []string
+	}{
+		{
+			[]WalkOption{WalkOrder(BreadthFirst)},
+			[]string{"/root", "/root/a.txt", "/root/b", "/root/f", "/root/b/c.txt", "/root/b/d", "/root/f/g.txt", "/root/b/d/e.txt"},
+		},
+		{
+			[]WalkOption{WalkMaxDepth(1)},
+			[]string{"/root", "/root/a.txt", "/root/b", "/root/f"},
+		},
+		{
+			[]WalkOption{WalkOrder(BreadthFirst), WalkMaxDepth(0)},
+			[]string{"/root"},
+		},
+	}
+
+	for _, test := range tests {
+		var visited []string
+		if err := connection.WalkDir("/root", func(p string, d fs.DirEntry, err error) error {
+			visited = append(visited, p)
+			return err
+		}, test.opts...); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(visited, test.want) {
+			t.Errorf("visited %q, want %q", visited, test.want)
+		}
+	}
+}
+
+func TestWalkDirLoop(t *testing.T) {
+	s := walkServer(t)
+	defer s.Close()
+	s.Link("/root/b/d/up", "/root")
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	for _, order := range []Order{DepthFirst, BreadthFirst} {
+		var visited int
+		if err := connection.WalkDir("/root", func(p string, d fs.DirEntry, err error) error {
+			if visited++; visited > 100 {
+				return fs.SkipAll
+			}
+			return err
+		}, WalkOrder(order)); err != nil {
+			t.Fatal(err)
+		}
+		if visited != 9 {
+			t.Errorf("order %d: visited %d entries, want 9", order, visited)
+		}
+	}
+}
+
+func TestWalkDirSharedLinks(t *testing.T) {
+	s := ftptest.NewServer()
+	defer s.Close()
+	s.WriteFile("/shared/f.txt", []byte("f"))
+	s.Mkdir("/top")
+	s.Link("/top/a", "/shared")
+	s.Link("/top/b", "/shared")
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	for _, order := range []Order{DepthFirst, BreadthFirst} {
+		var files []string
+		if err := connection.WalkDir("/", func(p string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				files = append(files, p)
+			}
+			return err
+		}, WalkOrder(order)); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(files)
+		if want := []string{"/shared/f.txt", "/top/a/f.txt", "/top/b/f.txt"}; !reflect.DeepEqual(files, want) {
+			t.Errorf("order %d: files %q, want %q", order, files, want)
+		}
+	}
+}
+
+func TestWalkPaths(t *testing.T) {
+	s := walkServer(t)
+	defer s.Close()
+
+	connection := connect(t, s)
+	defer connection.Close()
+
+	for _, root := range []string{"/root", "/root/"} {
+		var files []string
+		if err := connection.Walk(root, func(p string, info os.FileMode, err error) error {
+			files = append(files, p)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []string{"/root/a.txt", "/root/b/c.txt", "/root/b/d/e.txt", "/root/f/g.txt"}
+		if !reflect.DeepEqual(files, want) {
+			t.Errorf("Walk(%q) visited %q, want %q", root, files, want)
+		}
+	}
+}